@@ -78,6 +78,28 @@ func commandServe() *cobra.Command {
 	serveCmd.Flags().Bool("log-timestamp", true, "Prefix each log line with timestamp")
 	serveCmd.Flags().String("log-level", "info", "Log level (one of panic, fatal, error, warn, info or debug)")
 	serveCmd.Flags().StringArray("rtm-required-scope", nil, "Require specific scope when checking auth for RTM")
+	serveCmd.Flags().Int("rtm-session-resume-grace", 30, "Number of seconds for which RTM sessions of dropped connections can be resumed")
+	serveCmd.Flags().Int("rtm-session-replay-size", 128, "Number of messages per RTM session which are kept to be replayed when the session is resumed")
+	serveCmd.Flags().Int("rtm-max-connections-per-user", 0, "Maximum number of concurrent RTM connections per user, 0 for unlimited")
+	serveCmd.Flags().String("rtm-connection-limit-policy", "evict-oldest", "Policy when a user exceeds the RTM connection limit (one of reject, evict-oldest or evict-idle)")
+	serveCmd.Flags().StringArray("rtm-rate-limit", nil, "Rate limit rule for incoming RTM messages (format [connection|user:]TYPE[/SUBTYPE]=RATE[,BURST], RATE in messages per second)")
+	serveCmd.Flags().Int("rtm-rate-limit-disconnect", 0, "Number of rate limited RTM messages per minute after which a connection is disconnected, 0 to never disconnect")
+	serveCmd.Flags().String("rtm-group-lobby-regexp", "", "If set, joiners of groups matching this regex wait in a lobby until admitted by a member (example: ^public/.* )")
+	serveCmd.Flags().Bool("rtm-group-lobby-guests-only", true, "If set, only guests wait in group lobbies")
+	serveCmd.Flags().Int("rtm-group-max-participants", 0, "Maximum number of participants per group, 0 for unlimited")
+	serveCmd.Flags().StringArray("rtm-group-max-participants-rule", nil, "Maximum number of participants for groups matching a regex (format REGEXP=MAX), first match wins")
+	serveCmd.Flags().StringArray("rtm-profile-claim", nil, "Claim of authenticated users to expose as part of their profile to other users (can be used multiple times)")
+	serveCmd.Flags().String("rtm-meetings-file", "", "Full path to the file for scheduled meetings, enables scheduled meetings when set")
+	serveCmd.Flags().Int("rtm-call-ring-timeout", 0, "Number of seconds after which unanswered calls are hung up, 0 to ring until the caller hangs up")
+	serveCmd.Flags().StringArray("rtm-hunt-group", nil, "Named group of users which are called in parallel, first answer wins (format NAME=USER[,USER...])")
+	serveCmd.Flags().Int("rtm-drain-timeout", 0, "Maximum number of seconds to wait for active group channels before exiting on SIGTERM, 0 to exit without draining")
+	serveCmd.Flags().String("rtm-drain-url", "", "URL clients are asked to connect to instead when draining")
+	serveCmd.Flags().Int("rtm-drain-retry-after", 30, "Number of seconds clients are asked to wait before reconnecting when draining")
+	serveCmd.Flags().String("chats-history-db", "", "Full path to the database file for chats history, enables chats history when set")
+	serveCmd.Flags().StringArray("chats-history-retention", []string{"@=720h,1000"}, "Chats history retention rule for channels with a prefix (format PREFIX=MAXAGE[,MAXCOUNT], 0 for no limit)")
+	serveCmd.Flags().String("chats-richtext-policy", "clean", "Policy for chats rich text which is not allowed (one of clean or reject)")
+	serveCmd.Flags().StringArray("chats-richtext-allow", nil, "Allowed chats rich text tag with its allowed attributes (format TAG[:ATTR,...]), replaces the built-in list")
+	serveCmd.Flags().StringArray("chats-richtext-scheme", nil, "Allowed chats rich text link scheme, replaces the built-in list")
 	serveCmd.Flags().Bool("enable-guest-api", false, "Enables the guest API endpoints")
 	serveCmd.Flags().Bool("allow-guest-only-channels", false, "If set, guests can join empty channels")
 	serveCmd.Flags().String("public-guest-access-regexp", "", "If set, rooms matching this regex can be accessed by guest without invitation (example: ^group/public/.* )")
@@ -338,6 +360,28 @@ func serve(cmd *cobra.Command, args []string) error {
 		if len(config.RTMRequiredScopes) > 0 {
 			logger.WithField("required_scopes", config.RTMRequiredScopes).Infoln("rtm: access requirements set up")
 		}
+		config.RTMSessionResumeGrace, _ = cmd.Flags().GetInt("rtm-session-resume-grace")
+		config.RTMSessionReplaySize, _ = cmd.Flags().GetInt("rtm-session-replay-size")
+		config.RTMMaxConnectionsPerUser, _ = cmd.Flags().GetInt("rtm-max-connections-per-user")
+		config.RTMConnectionLimitPolicy, _ = cmd.Flags().GetString("rtm-connection-limit-policy")
+		config.RTMRateLimits, _ = cmd.Flags().GetStringArray("rtm-rate-limit")
+		config.RTMRateLimitDisconnect, _ = cmd.Flags().GetInt("rtm-rate-limit-disconnect")
+		config.RTMGroupLobbyPattern, _ = cmd.Flags().GetString("rtm-group-lobby-regexp")
+		config.RTMGroupLobbyGuestsOnly, _ = cmd.Flags().GetBool("rtm-group-lobby-guests-only")
+		config.RTMGroupMaxParticipants, _ = cmd.Flags().GetInt("rtm-group-max-participants")
+		config.RTMGroupMaxParticipantsRules, _ = cmd.Flags().GetStringArray("rtm-group-max-participants-rule")
+		config.RTMProfileClaims, _ = cmd.Flags().GetStringArray("rtm-profile-claim")
+		config.RTMMeetingsFilePath, _ = cmd.Flags().GetString("rtm-meetings-file")
+		config.RTMCallRingTimeout, _ = cmd.Flags().GetInt("rtm-call-ring-timeout")
+		config.RTMHuntGroups, _ = cmd.Flags().GetStringArray("rtm-hunt-group")
+		config.RTMDrainTimeout, _ = cmd.Flags().GetInt("rtm-drain-timeout")
+		config.RTMDrainURL, _ = cmd.Flags().GetString("rtm-drain-url")
+		config.RTMDrainRetryAfter, _ = cmd.Flags().GetInt("rtm-drain-retry-after")
+		config.ChatsHistoryDatabasePath, _ = cmd.Flags().GetString("chats-history-db")
+		config.ChatsHistoryRetention, _ = cmd.Flags().GetStringArray("chats-history-retention")
+		config.ChatsRichTextPolicy, _ = cmd.Flags().GetString("chats-richtext-policy")
+		config.ChatsRichTextAllow, _ = cmd.Flags().GetStringArray("chats-richtext-allow")
+		config.ChatsRichTextSchemes, _ = cmd.Flags().GetStringArray("chats-richtext-scheme")
 	}
 
 	// Build specific initialization.
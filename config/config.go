@@ -39,6 +39,39 @@ type Config struct {
 	EnableRTMAPI      bool
 	RTMRequiredScopes []string
 
+	RTMSessionResumeGrace int
+	RTMSessionReplaySize  int
+
+	RTMMaxConnectionsPerUser int
+	RTMConnectionLimitPolicy string
+
+	RTMRateLimits          []string
+	RTMRateLimitDisconnect int
+
+	RTMGroupLobbyPattern    string
+	RTMGroupLobbyGuestsOnly bool
+
+	RTMGroupMaxParticipants      int
+	RTMGroupMaxParticipantsRules []string
+
+	RTMProfileClaims []string
+
+	RTMMeetingsFilePath string
+
+	RTMCallRingTimeout int
+	RTMHuntGroups      []string
+
+	RTMDrainTimeout    int
+	RTMDrainURL        string
+	RTMDrainRetryAfter int
+
+	ChatsHistoryDatabasePath string
+	ChatsHistoryRetention    []string
+
+	ChatsRichTextPolicy  string
+	ChatsRichTextAllow   []string
+	ChatsRichTextSchemes []string
+
 	EnableGuestAPI           bool
 	GuestsCanCreateChannels  bool
 	GuestPublicAccessPattern string
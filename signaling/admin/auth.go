@@ -89,6 +89,9 @@ func (m *Manager) ValidateAdminAuthTokenString(tokenString string) (*api.AdminAu
 			return nil, fmt.Errorf("token signature invalid failed: %v", err)
 		}
 	}
+	if token == nil {
+		return nil, fmt.Errorf("token invalid: %v", err)
+	}
 
 	claims := token.Claims.(*jwt.StandardClaims)
 	return &api.AdminAuthToken{
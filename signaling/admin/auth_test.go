@@ -236,3 +236,87 @@ func TestAdminAuthTokensHandler(t *testing.T) {
 		}
 	}
 }
+
+func TestRequireAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	httpServer, manager, _ := newTestManager(ctx, t)
+	defer httpServer.Close()
+	manager.SetBasicAuth([]string{"dXNlcjpwYXNz"})
+
+	token := &api.AdminAuthToken{
+		Type:      api.AdminAuthTokenTypeToken,
+		Subject:   "unit-test",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+	tokenValue, err := manager.SignAdminAuthToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetToken(getAdminAuthTokenTokensRecordID(token), token)
+
+	handler := manager.RequireAuth(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		description    string
+		url            string
+		authorization  string
+		expectedStatus int
+	}{
+		{
+			description:    "no auth",
+			url:            "/",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			description:    "valid token",
+			url:            "/",
+			authorization:  "Token " + tokenValue,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			description:    "invalid token",
+			url:            "/",
+			authorization:  "Token invalid",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			description:    "valid basic auth",
+			url:            "/?user=admin",
+			authorization:  "Basic dXNlcjpwYXNz",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			description:    "valid basic auth without user",
+			url:            "/",
+			authorization:  "Basic dXNlcjpwYXNz",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			description:    "invalid basic auth",
+			url:            "/?user=admin",
+			authorization:  "Basic aW52YWxpZA==",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range tests {
+		req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != tc.expectedStatus {
+			t.Errorf("%s handler returned wrong status code: got %v want %v", tc.description, status, tc.expectedStatus)
+		}
+	}
+}
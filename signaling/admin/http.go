@@ -31,3 +31,20 @@ func (m *Manager) AddRoutes(ctx context.Context, router *mux.Router, wrapper fun
 
 	return router
 }
+
+// RequireAuth wraps the provided handler, so it is only called for requests
+// with a valid admin auth token or basic auth. All other requests are refused.
+func (m *Manager) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+
+		if _, ok := m.IsValidAdminAuthTokenRequest(req); !ok {
+			if _, ok = m.IsValidBasicAuthRequest(req); !ok {
+				http.Error(rw, "auth failed", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(rw, req)
+	})
+}
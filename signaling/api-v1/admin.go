@@ -30,6 +30,9 @@ type AdminAuthToken struct {
 	Auth              string          `json:"-"`
 	GroupRestriction  map[string]bool `json:"-"`
 	CanCreateChannels bool            `json:"-"`
+	Moderator         bool            `json:"-"`
+	ModeratorGroups   map[string]bool `json:"-"`
+	MaxParticipants   int             `json:"-"`
 }
 
 // Name returns the associated tokens name claim string value or empty string.
@@ -44,3 +47,56 @@ func (aat *AdminAuthToken) Name() string {
 
 	return ""
 }
+
+// IsGuest returns true if the associated token is a guest token. Guests are
+// always restricted to groups.
+func (aat *AdminAuthToken) IsGuest() bool {
+	return aat.GroupRestriction != nil
+}
+
+// IsModerator returns true if the associated token grants moderation of the
+// provided group.
+func (aat *AdminAuthToken) IsModerator(group string) bool {
+	return aat.Moderator || aat.ModeratorGroups[group]
+}
+
+// AdminRTMChannel defines the admin view of a RTM channel.
+type AdminRTMChannel struct {
+	ID           string   `json:"id"`
+	Group        string   `json:"group,omitempty"`
+	Members      []string `json:"members"`
+	Lobby        int      `json:"lobby,omitempty"`
+	PipelineMode string   `json:"pipeline_mode,omitempty"`
+	Created      int64    `json:"created"`
+}
+
+// AdminRTMUser defines the admin view of a RTM user.
+type AdminRTMUser struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name,omitempty"`
+	Guest       bool     `json:"guest,omitempty"`
+	Connections []string `json:"connections"`
+	Since       int64    `json:"since"`
+	Duration    int64    `json:"duration"`
+}
+
+// AdminRTMConnection defines the admin view of a RTM connection.
+type AdminRTMConnection struct {
+	ID         string   `json:"id"`
+	User       string   `json:"user,omitempty"`
+	Duration   int64    `json:"duration"`
+	LastActive int64    `json:"last_active"`
+	Channels   []string `json:"channels"`
+}
+
+// AdminServerNotice defines a server notice together with its recipients as
+// managed with the admin API. Without users and groups, the notice is for
+// everyone. Expires is the unix timestamp when the notice is removed, it
+// defaults to the start of the scheduled maintenance.
+type AdminServerNotice struct {
+	*ServerNotice
+
+	Users   []string `json:"users,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Expires int64    `json:"expires,omitempty"`
+}
@@ -28,6 +28,27 @@ type Self struct {
 // ServerStatus contains server status information to share with clients.
 type ServerStatus struct {
 	Kustomer *uint64 `json:"kustomer,omitempty"`
+
+	Notices []*ServerNotice `json:"notices,omitempty"`
+}
+
+// Server notice severities.
+const (
+	ServerNoticeSeverityInfo     = "info"
+	ServerNoticeSeverityWarning  = "warning"
+	ServerNoticeSeverityCritical = "critical"
+)
+
+// ServerNotice is an operator notice to share with clients. Maintenance is the
+// unix timestamp of an optional scheduled maintenance start and Localized
+// holds optional variants of Message by language tag.
+type ServerNotice struct {
+	ID          string            `json:"id"`
+	Message     string            `json:"message"`
+	Severity    string            `json:"severity"`
+	Maintenance int64             `json:"maintenance,omitempty"`
+	Localized   map[string]string `json:"localized,omitempty"`
+	Created     int64             `json:"created"`
 }
 
 // Equal reports wether serverStatus and otherServers are "deeply equal".
@@ -26,5 +26,7 @@ const (
 
 // Claims as known by kwm server.
 const (
-	NameClaim = "name"
+	NameClaim            = "name"
+	ModeratorClaim       = "kopano/kwm/moderator"
+	MaxParticipantsClaim = "kopano/kwm/max_participants"
 )
@@ -32,8 +32,9 @@ const (
 	RTMTypeNameGoodbye = "goodbye"
 	RTMTypeNameServer  = "server"
 
-	RTMTypeNameWebRTC = "webrtc"
-	RTMTypeNameChats  = "chats"
+	RTMTypeNameWebRTC   = "webrtc"
+	RTMTypeNameChats    = "chats"
+	RTMTypeNamePresence = "presence"
 
 	RTMSubtypeNameWebRTCCall    = "webrtc_call"
 	RTMSubtypeNameWebRTCChannel = "webrtc_channel"
@@ -42,18 +43,73 @@ const (
 
 	RTMSubtypeNameWebRTCGroup = "webrtc_group"
 
-	RTMSubtypeNameChatsMessage = "chats_message"
-	RTMSubtypeNameChatsSystem  = "chats_system"
+	RTMSubtypeNameWebRTCKick      = "webrtc_kick"
+	RTMSubtypeNameWebRTCMute      = "webrtc_mute"
+	RTMSubtypeNameWebRTCModerator = "webrtc_moderator"
+
+	RTMSubtypeNameWebRTCLobby = "webrtc_lobby"
+	RTMSubtypeNameWebRTCAdmit = "webrtc_admit"
+	RTMSubtypeNameWebRTCDeny  = "webrtc_deny"
+
+	RTMSubtypeNameWebRTCState = "webrtc_state"
+
+	RTMSubtypeNameWebRTCPasscode = "webrtc_passcode"
 
-	RTMErrorIDServerError      = "server_error"
-	RTMErrorIDBadMessage       = "bad_message"
-	RTMErrorIDNoSessionForUser = "no_session_for_user"
-	RTMErrorIDAccessRestricted = "access_restricted"
-	RTMErrorIDCreateRestricted = "create_restricted"
+	RTMSubtypeNameWebRTCTransfer = "webrtc_transfer"
+	RTMSubtypeNameWebRTCEscalate = "webrtc_escalate"
 
-	RTMChatsMessageKindMessageUserText = ""
-	RTMChatsMessageKindMessageQueued   = "delivery_queued"
-	RTMChatsMessageKindSystemText      = "system"
+	RTMSubtypeNameChatsMessage = "chats_message"
+	RTMSubtypeNameChatsSystem  = "chats_system"
+	RTMSubtypeNameChatsTyping  = "chats_typing"
+	RTMSubtypeNameChatsHistory = "chats_history"
+	RTMSubtypeNameChatsEdit    = "chats_edit"
+	RTMSubtypeNameChatsDelete  = "chats_delete"
+	RTMSubtypeNameChatsReact   = "chats_react"
+	RTMSubtypeNameChatsRead    = "chats_read"
+
+	RTMSubtypeNamePresenceSubscribe   = "presence_subscribe"
+	RTMSubtypeNamePresenceUnsubscribe = "presence_unsubscribe"
+	RTMSubtypeNamePresenceSet         = "presence_set"
+	RTMSubtypeNamePresenceUpdate      = "presence_update"
+
+	RTMErrorIDServerError       = "server_error"
+	RTMErrorIDBadMessage        = "bad_message"
+	RTMErrorIDNoSessionForUser  = "no_session_for_user"
+	RTMErrorIDAccessRestricted  = "access_restricted"
+	RTMErrorIDCreateRestricted  = "create_restricted"
+	RTMErrorIDConnectionLimit   = "connection_limit_exceeded"
+	RTMErrorIDRichTextRejected  = "rich_text_rejected"
+	RTMErrorIDRateLimited       = "rate_limited"
+	RTMErrorIDChannelFull       = "channel_full"
+	RTMErrorIDPasscodeRequired  = "passcode_required"
+	RTMErrorIDPasscodeInvalid   = "passcode_invalid"
+	RTMErrorIDMeetingNotActive  = "meeting_not_active"
+	RTMErrorIDAnsweredElsewhere = "answered_elsewhere"
+
+	RTMGoodbyeReasonConnectionLimit = "connection_limit"
+	RTMGoodbyeReasonRateLimited     = "rate_limited"
+	RTMGoodbyeReasonAdmin           = "admin"
+	RTMGoodbyeReasonDraining        = "draining"
+
+	RTMHangupReasonTimeout = "timeout"
+
+	RTMTransferModeBlind    = "blind"
+	RTMTransferModeAttended = "attended"
+
+	RTMChatsMessageKindMessageUserText  = ""
+	RTMChatsMessageKindMessageQueued    = "delivery_queued"
+	RTMChatsMessageKindMessageDelivered = "delivery_delivered"
+	RTMChatsMessageKindMessageRead      = "delivery_read"
+	RTMChatsMessageKindSystemText       = "system"
+
+	RTMLobbyStateWaiting  = "waiting"
+	RTMLobbyStateAdmitted = "admitted"
+	RTMLobbyStateDenied   = "denied"
+
+	RTMPresenceStatusOnline  = "online"
+	RTMPresenceStatusAway    = "away"
+	RTMPresenceStatusBusy    = "busy"
+	RTMPresenceStatusOffline = "offline"
 )
 
 // RTMConnectResponse is the response returned from rtm.connect.
@@ -122,9 +178,27 @@ type RTMTypeHello struct {
 	Type string `json:"type"`
 	Self *Self  `json:"self,omitempty"`
 
+	Resume  *RTMDataResume `json:"resume,omitempty"`
+	Reason  string         `json:"reason,omitempty"`
+	Message string         `json:"message,omitempty"`
+
+	URL        string `json:"url,omitempty"`
+	RetryAfter int64  `json:"retry_after,omitempty"`
+
 	ServerStatus *ServerStatus `json:"server_status,omitempt"`
 }
 
+// RTMDataResume defines the session resume data sent with hello. Clients pass
+// the token with their next connect request to resume the session, together
+// with the sequence number of the last message they have seen. Incomplete is
+// set, if not all missed messages could be replayed.
+type RTMDataResume struct {
+	Token      string `json:"token"`
+	Resumed    bool   `json:"resumed"`
+	Grace      int64  `json:"grace"`
+	Incomplete bool   `json:"incomplete,omitempty"`
+}
+
 // RTMTypeError is the error reply.
 type RTMTypeError struct {
 	*RTMTypeEnvelopeReply
@@ -166,6 +240,8 @@ type RTMTypePingPong map[string]interface{}
 type RTMTypeWebRTC struct {
 	*RTMTypeSubtypeEnvelope
 	Target      string          `json:"target"`
+	Targets     []string        `json:"targets,omitempty"`
+	Hunt        string          `json:"hunt,omitempty"`
 	Source      string          `json:"source"`
 	Profile     *RTMDataProfile `json:"profile,omitempty"`
 	Initiator   bool            `json:"initiator,omitempty"`
@@ -213,11 +289,31 @@ type RTMTypeChatsReply struct {
 	Data    json.RawMessage `json:"data,omitempty"`
 }
 
+// RTMTypePresence defines presence related messages.
+type RTMTypePresence struct {
+	*RTMTypeSubtypeEnvelope
+	Version uint64          `json:"v"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// RTMTypePresenceReply defines presence related replies.
+type RTMTypePresenceReply struct {
+	*RTMTypeSubtypeEnvelopeReply
+	Version uint64          `json:"v"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
 // RTMDataWebRTCAccept defines webrtc extra accept data.
 type RTMDataWebRTCAccept struct {
-	Accept bool   `json:"accept"`
-	State  string `json:"state,omitempty"`
-	Reason string `json:"reason"`
+	Accept   bool     `json:"accept"`
+	State    string   `json:"state,omitempty"`
+	Reason   string   `json:"reason"`
+	Rejected []string `json:"rejected,omitempty"`
+}
+
+// RTMDataWebRTCHangup defines webrtc extra hangup data.
+type RTMDataWebRTCHangup struct {
+	Reason string `json:"reason,omitempty"`
 }
 
 // RTMDataWebRTCChannelExtra defines webrtc channel extra data.
@@ -225,13 +321,84 @@ type RTMDataWebRTCChannelExtra struct {
 	Group    *RTMTDataWebRTCChannelGroup   `json:"group,omitempty"`
 	Pipeline *RTMDataWebRTCChannelPipeline `json:"pipeline,omitempty"`
 	Replaced bool                          `json:"replaced,omitempty"`
+	Replaces string                        `json:"replaces,omitempty"`
 }
 
 // RTMTDataWebRTCChannelGroup defnes webrtc channel group details.
 type RTMTDataWebRTCChannelGroup struct {
-	Group   string   `json:"group"`
-	Members []string `json:"members"`
-	Reset   bool     `json:"reset"`
+	Group      string   `json:"group"`
+	Members    []string `json:"members"`
+	Reset      bool     `json:"reset"`
+	Moderators []string `json:"moderators,omitempty"`
+
+	Profiles map[string]*RTMDataProfile     `json:"profiles,omitempty"`
+	States   map[string]*RTMDataWebRTCState `json:"states,omitempty"`
+}
+
+// RTMDataWebRTCPasscode defines webrtc group passcode data. Joiners send it
+// with group requests, moderators send it to set the passcode of a group. An
+// empty passcode removes the passcode of a group.
+type RTMDataWebRTCPasscode struct {
+	Passcode string `json:"passcode"`
+}
+
+// RTMDataWebRTCTransfer defines webrtc call transfer data. The transferring
+// user sends it with the mode, the target user to transfer to and for attended
+// transfers the channel of the consultation call with the target. The server
+// sends it to the parties which are connected, with the target being their new
+// peer and replaces being the channel of the call which is replaced.
+type RTMDataWebRTCTransfer struct {
+	Mode     string `json:"mode"`
+	Target   string `json:"target"`
+	Channel  string `json:"channel,omitempty"`
+	Replaces string `json:"replaces,omitempty"`
+}
+
+// RTMDataWebRTCEscalate defines webrtc call escalation data. The escalating
+// user sends it with the group to move the call to, which is generated when
+// empty, the passcode of that group if required and the users to invite into
+// the group. Invite can also be sent for group channels, to invite further
+// users.
+type RTMDataWebRTCEscalate struct {
+	Group    string   `json:"group,omitempty"`
+	Passcode string   `json:"passcode,omitempty"`
+	Invite   []string `json:"invite,omitempty"`
+}
+
+// RTMDataWebRTCState defines the state of a webrtc group participant. In
+// updates only the set fields change, custom keys with null value are removed.
+type RTMDataWebRTCState struct {
+	Hand       *bool `json:"hand,omitempty"`
+	AudioMuted *bool `json:"audio_muted,omitempty"`
+	VideoMuted *bool `json:"video_muted,omitempty"`
+	Sharing    *bool `json:"sharing,omitempty"`
+
+	Custom map[string]interface{} `json:"custom,omitempty"`
+}
+
+// RTMDataWebRTCModeration defines webrtc group moderation data. Duration is
+// the number of seconds a kicked participant cannot rejoin, Kind is the media
+// kind a participant is asked to mute and Moderator grants or revokes the
+// moderator role.
+type RTMDataWebRTCModeration struct {
+	Reason    string `json:"reason,omitempty"`
+	Duration  int64  `json:"duration,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Moderator bool   `json:"moderator,omitempty"`
+}
+
+// RTMDataWebRTCLobby defines webrtc group lobby data. Joiners receive their
+// state, members receive the pending joiners.
+type RTMDataWebRTCLobby struct {
+	State   string                     `json:"state,omitempty"`
+	Pending []*RTMDataWebRTCLobbyEntry `json:"pending,omitempty"`
+}
+
+// RTMDataWebRTCLobbyEntry defines a joiner waiting in a webrtc group lobby.
+type RTMDataWebRTCLobbyEntry struct {
+	User    string          `json:"user"`
+	Profile *RTMDataProfile `json:"profile,omitempty"`
+	TS      int64           `json:"ts"`
 }
 
 // RTMDataWebRTCChannelPipeline defines webrtc channel pipeline details.
@@ -243,7 +410,10 @@ type RTMDataWebRTCChannelPipeline struct {
 // RTMDataProfile defines user profile data which the RTM server can deliver
 // to clients.
 type RTMDataProfile struct {
-	Name string `json:"name,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Guest bool   `json:"guest,omitempty"`
+
+	Claims map[string]interface{} `json:"claims,omitempty"`
 }
 
 // RTMDataChatsMessage defines chats channel chat message data.
@@ -256,5 +426,72 @@ type RTMDataChatsMessage struct {
 	RichText string `json:"richText,omitempty"`
 	ID       string `json:"id"`
 
+	Targets []string `json:"targets,omitempty"`
+	Private bool     `json:"private,omitempty"`
+
+	Edited    int64               `json:"edited,omitempty"`
+	Deleted   bool                `json:"deleted,omitempty"`
+	Reactions map[string][]string `json:"reactions,omitempty"`
+
 	Extra map[string]interface{} `json:"extra,omitempty"`
 }
+
+// RTMDataChatsReaction defines chats channel message reaction data.
+type RTMDataChatsReaction struct {
+	ID       string `json:"id"`
+	Sender   string `json:"sender"`
+	Reaction string `json:"reaction"`
+	Remove   bool   `json:"remove,omitempty"`
+}
+
+// RTMDataChatsTyping defines chats channel typing indicator data.
+type RTMDataChatsTyping struct {
+	Sender  string `json:"sender"`
+	Typing  bool   `json:"typing"`
+	Expires int64  `json:"expires,omitempty"`
+}
+
+// RTMDataChatsRead defines chats channel read acknowledgement data.
+type RTMDataChatsRead struct {
+	IDs []string `json:"ids"`
+}
+
+// RTMDataChatsReceipts defines chats channel message delivery receipt data,
+// sent to the sender of a message as extra data of delivery system messages.
+type RTMDataChatsReceipts struct {
+	Recipients int      `json:"recipients"`
+	Delivered  []string `json:"delivered"`
+	Read       []string `json:"read"`
+}
+
+// RTMDataChatsHistoryRequest defines chats channel history request data.
+type RTMDataChatsHistoryRequest struct {
+	Before   string `json:"before,omitempty"`
+	BeforeTS int64  `json:"before_ts,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+}
+
+// RTMDataChatsHistory defines chats channel history data.
+type RTMDataChatsHistory struct {
+	Messages []*RTMDataChatsMessage     `json:"messages"`
+	Profiles map[string]*RTMDataProfile `json:"profiles,omitempty"`
+	More     bool                       `json:"more"`
+}
+
+// RTMDataPresenceSubscribe defines presence subscribe and unsubscribe data.
+type RTMDataPresenceSubscribe struct {
+	Users []string `json:"users"`
+}
+
+// RTMDataPresenceUpdate defines presence update data.
+type RTMDataPresenceUpdate struct {
+	Users []*RTMDataUserPresence `json:"users"`
+}
+
+// RTMDataUserPresence defines the presence of a single user.
+type RTMDataUserPresence struct {
+	User   string `json:"user,omitempty"`
+	Status string `json:"status"`
+	Text   string `json:"text,omitempty"`
+	TS     int64  `json:"ts,omitempty"`
+}
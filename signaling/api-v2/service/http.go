@@ -60,6 +60,15 @@ func (h *HTTPService) AddRoutes(ctx context.Context, router *mux.Router, wrapper
 	if adminm, ok := h.services.AdminManager.(*admin.Manager); ok {
 		r := v2.PathPrefix("/admin").Subrouter()
 		adminm.AddRoutes(ctx, r, wrapper)
+
+		if rtmm, ok := h.services.RTMManager.(*rtm.Manager); ok {
+			// RTM admin routes are only available with admin auth.
+			adminWrapper := func(next http.Handler) http.Handler {
+				return wrapper(adminm.RequireAuth(next))
+			}
+			rtmm.AddAdminRoutes(ctx, r.PathPrefix("/rtm").Subrouter(), adminWrapper)
+			rtmm.AddMeetingsRoutes(ctx, r.PathPrefix("/meetings").Subrouter(), adminWrapper)
+		}
 	}
 
 	if mcum, ok := h.services.MCUManager.(*mcu.Manager); ok {
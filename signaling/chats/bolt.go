@@ -0,0 +1,374 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chats
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	boltPurgeInterval = time.Duration(10) * time.Minute
+	boltOpenTimeout   = time.Duration(5) * time.Second
+)
+
+var (
+	boltBucketChannels = []byte("channels")
+	boltBucketMessages = []byte("messages")
+	boltBucketIDs      = []byte("ids")
+)
+
+// BoltStore is a Store which records into an embedded bbolt database file.
+//
+// Every channel has its own bucket, which holds the JSON encoded records keyed
+// by a sequence number and an index of message IDs to sequence numbers.
+type BoltStore struct {
+	db        *bolt.DB
+	retention Retention
+	logger    logrus.FieldLogger
+}
+
+// NewBoltStore opens the bbolt database at the provided path and returns a
+// BoltStore using it with the provided retention rules. Records exceeding
+// their retention limits are purged periodically until the provided context
+// is done.
+func NewBoltStore(ctx context.Context, path string, retention Retention, logger logrus.FieldLogger) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: boltOpenTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, bucketErr := tx.CreateBucketIfNotExists(boltBucketChannels)
+		return bucketErr
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &BoltStore{
+		db:        db,
+		retention: retention,
+		logger:    logger.WithField("chats_store", "bolt"),
+	}
+
+	// Purge function.
+	go func() {
+		ticker := time.NewTicker(boltPurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if purgeErr := s.Purge(ctx); purgeErr != nil {
+					s.logger.WithError(purgeErr).Errorln("failed to purge chats store")
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+// Add implements the Store interface.
+func (s *BoltStore) Add(ctx context.Context, channel string, record *Record) error {
+	rule := s.retention.Lookup(channel)
+	if rule == nil {
+		return nil
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		cb, err := tx.Bucket(boltBucketChannels).CreateBucketIfNotExists([]byte(channel))
+		if err != nil {
+			return err
+		}
+		messages, err := cb.CreateBucketIfNotExists(boltBucketMessages)
+		if err != nil {
+			return err
+		}
+		ids, err := cb.CreateBucketIfNotExists(boltBucketIDs)
+		if err != nil {
+			return err
+		}
+
+		seq, err := messages.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := boltKey(seq)
+		if err = messages.Put(key, value); err != nil {
+			return err
+		}
+		if err = ids.Put([]byte(record.Message.ID), key); err != nil {
+			return err
+		}
+
+		if rule.MaxCount > 0 {
+			return boltPurgeCount(messages, ids, rule.MaxCount)
+		}
+		return nil
+	})
+}
+
+// Get implements the Store interface.
+func (s *BoltStore) Get(ctx context.Context, channel string, id string) (*Record, error) {
+	var record *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cb := tx.Bucket(boltBucketChannels).Bucket([]byte(channel))
+		if cb == nil {
+			return ErrMessageNotFound
+		}
+		key := cb.Bucket(boltBucketIDs).Get([]byte(id))
+		if key == nil {
+			return ErrMessageNotFound
+		}
+		value := cb.Bucket(boltBucketMessages).Get(key)
+		if value == nil {
+			return ErrMessageNotFound
+		}
+
+		return json.Unmarshal(value, &record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// Update implements the Store interface.
+func (s *BoltStore) Update(ctx context.Context, channel string, record *Record) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		cb := tx.Bucket(boltBucketChannels).Bucket([]byte(channel))
+		if cb == nil {
+			return ErrMessageNotFound
+		}
+		key := cb.Bucket(boltBucketIDs).Get([]byte(record.Message.ID))
+		if key == nil {
+			return ErrMessageNotFound
+		}
+
+		return cb.Bucket(boltBucketMessages).Put(key, value)
+	})
+}
+
+// History implements the Store interface.
+func (s *BoltStore) History(ctx context.Context, channel string, query *HistoryQuery) ([]*Record, bool, error) {
+	query.Normalize()
+
+	records := make([]*Record, 0)
+	more := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cb := tx.Bucket(boltBucketChannels).Bucket([]byte(channel))
+		if cb == nil {
+			return nil
+		}
+		messages := cb.Bucket(boltBucketMessages)
+		ids := cb.Bucket(boltBucketIDs)
+
+		c := messages.Cursor()
+		var k, v []byte
+		if query.Before != "" {
+			key := ids.Get([]byte(query.Before))
+			if key == nil {
+				return ErrMessageNotFound
+			}
+			if k, _ = c.Seek(key); k != nil {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Last()
+		}
+
+		for ; k != nil; k, v = c.Prev() {
+			if len(records) >= query.Limit {
+				more = true
+				break
+			}
+			var record *Record
+			if err := json.Unmarshal(v, &record); err != nil || record == nil || record.Message == nil {
+				s.logger.WithError(err).WithField("channel", channel).Warnln("skipping invalid chats store record")
+				continue
+			}
+			if query.BeforeTS > 0 && record.Message.TS >= query.BeforeTS {
+				continue
+			}
+			records = append(records, record)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Reverse, oldest first.
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	return records, more, nil
+}
+
+// Purge implements the Store interface.
+func (s *BoltStore) Purge(ctx context.Context) error {
+	now := time.Now()
+	purged := 0
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		channels := tx.Bucket(boltBucketChannels)
+		empty := make([][]byte, 0)
+		err := channels.ForEach(func(channel, _ []byte) error {
+			cb := channels.Bucket(channel)
+			if cb == nil {
+				return nil
+			}
+			messages := cb.Bucket(boltBucketMessages)
+			ids := cb.Bucket(boltBucketIDs)
+
+			rule := s.retention.Lookup(string(channel))
+			if rule == nil {
+				// No longer retained at all.
+				empty = append(empty, channel)
+				return nil
+			}
+
+			if rule.MaxAge > 0 {
+				deadline := now.Add(-rule.MaxAge).Unix()
+				expired := make([][]byte, 0)
+				c := messages.Cursor()
+				for k, v := c.First(); k != nil; k, v = c.Next() {
+					var record *Record
+					if err := json.Unmarshal(v, &record); err == nil && record != nil && record.Message != nil {
+						if record.Message.TS >= deadline {
+							break
+						}
+					}
+					expired = append(expired, append([]byte(nil), k...))
+				}
+				if err := boltDelete(messages, ids, expired); err != nil {
+					return err
+				}
+				purged += len(expired)
+			}
+			if rule.MaxCount > 0 {
+				if err := boltPurgeCount(messages, ids, rule.MaxCount); err != nil {
+					return err
+				}
+			}
+
+			if k, _ := messages.Cursor().First(); k == nil {
+				empty = append(empty, channel)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, channel := range empty {
+			if err = channels.DeleteBucket(channel); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if purged > 0 {
+		s.logger.WithField("count", purged).Debugln("chats store purged expired records")
+	}
+	return err
+}
+
+// Close implements the Store interface.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func boltKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// boltCount returns the number of records in the provided messages bucket.
+// Records can be removed from anywhere, so the keys are walked instead of
+// deriving the count from the first and last sequence number.
+func boltCount(messages *bolt.Bucket) int {
+	count := 0
+	c := messages.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		count++
+	}
+
+	return count
+}
+
+func boltPurgeCount(messages *bolt.Bucket, ids *bolt.Bucket, maxCount int) error {
+	count := boltCount(messages)
+	if count <= maxCount {
+		return nil
+	}
+
+	expired := make([][]byte, 0, count-maxCount)
+	c := messages.Cursor()
+	for k, _ := c.First(); k != nil && count > maxCount; k, _ = c.Next() {
+		expired = append(expired, append([]byte(nil), k...))
+		count--
+	}
+
+	return boltDelete(messages, ids, expired)
+}
+
+func boltDelete(messages *bolt.Bucket, ids *bolt.Bucket, keys [][]byte) error {
+	for _, k := range keys {
+		var record *Record
+		if err := json.Unmarshal(messages.Get(k), &record); err == nil && record != nil && record.Message != nil {
+			if key := ids.Get([]byte(record.Message.ID)); bytes.Equal(key, k) {
+				if err = ids.Delete([]byte(record.Message.ID)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := messages.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chats
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+)
+
+func newTestBoltStore(ctx context.Context, t *testing.T, rules ...string) (*BoltStore, func()) {
+	dir, err := ioutil.TempDir("", "kwmserver-chats-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retention, err := NewRetention(rules)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	store, err := NewBoltStore(ctx, filepath.Join(dir, "chats.db"), retention, logrus.New())
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func addTestRecords(ctx context.Context, t *testing.T, store Store, channel string, count int) {
+	now := time.Now().Unix()
+	for i := 0; i < count; i++ {
+		err := store.Add(ctx, channel, &Record{
+			Message: &api.RTMDataChatsMessage{
+				ID:   strconv.Itoa(i),
+				TS:   now - int64(10*(count-i)),
+				Text: "message " + strconv.Itoa(i),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBoltStoreHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, cleanup := newTestBoltStore(ctx, t, "@=0")
+	defer cleanup()
+	addTestRecords(ctx, t, store, "@test", 10)
+
+	tests := []struct {
+		query *HistoryQuery
+		first string
+		last  string
+		more  bool
+	}{
+		{&HistoryQuery{Limit: 3}, "7", "9", true},
+		{&HistoryQuery{Before: "7", Limit: 3}, "4", "6", true},
+		{&HistoryQuery{Before: "3", Limit: 5}, "0", "2", false},
+		{&HistoryQuery{}, "0", "9", false},
+	}
+
+	for _, test := range tests {
+		records, more, err := store.History(ctx, "@test", test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) == 0 {
+			t.Fatalf("expected records for %+v", test.query)
+		}
+		if first := records[0].Message.ID; first != test.first {
+			t.Errorf("first record %v does not match expected %v", first, test.first)
+		}
+		if last := records[len(records)-1].Message.ID; last != test.last {
+			t.Errorf("last record %v does not match expected %v", last, test.last)
+		}
+		if more != test.more {
+			t.Errorf("more %v does not match expected %v", more, test.more)
+		}
+	}
+
+	if _, _, err := store.History(ctx, "@test", &HistoryQuery{Before: "unknown"}); err != ErrMessageNotFound {
+		t.Errorf("expected message not found error, got %v", err)
+	}
+}
+
+func TestBoltStoreRetention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, cleanup := newTestBoltStore(ctx, t, "@=0,5", "@short=5s")
+	defer cleanup()
+	addTestRecords(ctx, t, store, "@test", 10)
+	addTestRecords(ctx, t, store, "@short", 10)
+	addTestRecords(ctx, t, store, "*other", 10)
+
+	tests := []struct {
+		channel string
+		count   int
+	}{
+		{"@test", 5},
+		{"@short", 0},
+		{"*other", 0},
+	}
+
+	if err := store.Purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		records, _, err := store.History(ctx, test.channel, &HistoryQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != test.count {
+			t.Errorf("%v: record count %d does not match expected %d", test.channel, len(records), test.count)
+		}
+	}
+}
+
+func TestBoltStoreRetentionWithGaps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, cleanup := newTestBoltStore(ctx, t, "@=0,5")
+	defer cleanup()
+	addTestRecords(ctx, t, store, "@test", 5)
+
+	// Remove a record in the middle.
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		cb := tx.Bucket(boltBucketChannels).Bucket([]byte("@test"))
+		ids := cb.Bucket(boltBucketIDs)
+		return boltDelete(cb.Bucket(boltBucketMessages), ids, [][]byte{ids.Get([]byte("2"))})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Add(ctx, "@test", &Record{
+		Message: &api.RTMDataChatsMessage{
+			ID:   "5",
+			TS:   time.Now().Unix(),
+			Text: "message 5",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, _, err := store.History(ctx, "@test", &HistoryQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 {
+		t.Errorf("record count %d does not match expected %d", len(records), 5)
+	}
+	if len(records) > 0 && records[0].Message.ID != "0" {
+		t.Errorf("first record %v does not match expected %v", records[0].Message.ID, "0")
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A RetentionRule defines how long and how many records are kept for channels
+// with IDs starting with its prefix. Zero values mean no limit.
+type RetentionRule struct {
+	Prefix   string
+	MaxAge   time.Duration
+	MaxCount int
+}
+
+// Retention is a set of retention rules.
+type Retention []*RetentionRule
+
+// ParseRetentionRule parses the provided retention rule string value. The
+// format is `PREFIX=MAXAGE[,MAXCOUNT]`, where MAXAGE is a duration like `720h`
+// and both limits can be `0` for no limit.
+func ParseRetentionRule(value string) (*RetentionRule, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid retention rule: %v", value)
+	}
+
+	rule := &RetentionRule{
+		Prefix: parts[0],
+	}
+	limits := strings.SplitN(parts[1], ",", 2)
+	if limits[0] != "0" {
+		maxAge, err := time.ParseDuration(limits[0])
+		if err != nil || maxAge < 0 {
+			return nil, fmt.Errorf("invalid retention rule max age: %v", value)
+		}
+		rule.MaxAge = maxAge
+	}
+	if len(limits) > 1 {
+		maxCount, err := strconv.Atoi(limits[1])
+		if err != nil || maxCount < 0 {
+			return nil, fmt.Errorf("invalid retention rule max count: %v", value)
+		}
+		rule.MaxCount = maxCount
+	}
+
+	return rule, nil
+}
+
+// NewRetention parses all provided rule string values into a Retention.
+func NewRetention(values []string) (Retention, error) {
+	retention := make(Retention, 0, len(values))
+	for _, value := range values {
+		rule, err := ParseRetentionRule(value)
+		if err != nil {
+			return nil, err
+		}
+		retention = append(retention, rule)
+	}
+
+	return retention, nil
+}
+
+// Lookup returns the rule with the longest prefix matching the provided
+// channel ID, or nil if no rule matches.
+func (retention Retention) Lookup(channel string) *RetentionRule {
+	var match *RetentionRule
+	for _, rule := range retention {
+		if !strings.HasPrefix(channel, rule.Prefix) {
+			continue
+		}
+		if match == nil || len(rule.Prefix) > len(match.Prefix) {
+			match = rule
+		}
+	}
+
+	return match
+}
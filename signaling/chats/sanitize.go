@@ -0,0 +1,232 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chats
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Rich text policy modes.
+const (
+	RichTextPolicyModeClean  = "clean"
+	RichTextPolicyModeReject = "reject"
+)
+
+// ErrRichTextRejected is the error returned when rich text violates a policy
+// in reject mode.
+var ErrRichTextRejected = errors.New("rich text violates policy")
+
+// DefaultRichTextAllow is the default list of allowed tags with their allowed
+// attributes, matching what kwmjs generates.
+var DefaultRichTextAllow = []string{
+	"a:href,title",
+	"b", "strong", "i", "em", "u", "s", "strike", "del",
+	"p", "div", "span", "br",
+	"ul", "ol", "li",
+	"blockquote", "pre", "code",
+}
+
+// DefaultRichTextSchemes is the default list of allowed link schemes.
+var DefaultRichTextSchemes = []string{"http", "https", "mailto"}
+
+// richTextVoidTags is the set of tags which never have an end tag.
+var richTextVoidTags = map[string]bool{
+	"br":  true,
+	"hr":  true,
+	"img": true,
+	"wbr": true,
+}
+
+// richTextDropContentTags is the set of tags which are removed together with
+// their content, when they are not allowed.
+var richTextDropContentTags = map[string]bool{
+	"script": true,
+	"style":  true,
+}
+
+// richTextURLAttributes is the set of attributes which contain URLs.
+var richTextURLAttributes = map[string]bool{
+	"href": true,
+	"src":  true,
+	"cite": true,
+}
+
+// A RichTextPolicy is an allow-list of HTML tags, attributes and link schemes
+// for rich text chats messages.
+type RichTextPolicy struct {
+	reject  bool
+	tags    map[string]map[string]bool
+	schemes map[string]bool
+}
+
+// NewRichTextPolicy creates a RichTextPolicy with the provided mode, allowed
+// tags and allowed link schemes. Each allow value has the format
+// `TAG[:ATTR,...]`.
+func NewRichTextPolicy(mode string, allow []string, schemes []string) (*RichTextPolicy, error) {
+	policy := &RichTextPolicy{
+		tags:    make(map[string]map[string]bool),
+		schemes: make(map[string]bool),
+	}
+
+	switch mode {
+	case RichTextPolicyModeClean:
+	case RichTextPolicyModeReject:
+		policy.reject = true
+	default:
+		return nil, fmt.Errorf("unknown rich text policy mode: %v", mode)
+	}
+
+	for _, value := range allow {
+		parts := strings.SplitN(value, ":", 2)
+		tag := strings.ToLower(strings.TrimSpace(parts[0]))
+		if tag == "" || richTextDropContentTags[tag] {
+			return nil, fmt.Errorf("invalid rich text allow value: %v", value)
+		}
+		attributes := policy.tags[tag]
+		if attributes == nil {
+			attributes = make(map[string]bool)
+			policy.tags[tag] = attributes
+		}
+		if len(parts) > 1 {
+			for _, attribute := range strings.Split(parts[1], ",") {
+				attribute = strings.ToLower(strings.TrimSpace(attribute))
+				if attribute == "" || strings.HasPrefix(attribute, "on") || attribute == "style" {
+					return nil, fmt.Errorf("invalid rich text allow value: %v", value)
+				}
+				attributes[attribute] = true
+			}
+		}
+	}
+	for _, scheme := range schemes {
+		policy.schemes[strings.ToLower(strings.TrimSpace(scheme))] = true
+	}
+
+	return policy, nil
+}
+
+// Sanitize returns the provided rich text with everything removed which is
+// not allowed by the accociated policy. In reject mode, ErrRichTextRejected
+// is returned instead if anything had to be removed.
+func (policy *RichTextPolicy) Sanitize(value string) (string, error) {
+	var buf bytes.Buffer
+	violation := false
+
+	open := make([]string, 0)
+	skip := ""
+
+	tokenizer := html.NewTokenizer(strings.NewReader(value))
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				return "", tokenizer.Err()
+			}
+			break
+		}
+		token := tokenizer.Token()
+
+		if skip != "" {
+			// Inside of a removed tag with content.
+			if tt == html.EndTagToken && token.Data == skip {
+				skip = ""
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			buf.WriteString(html.EscapeString(token.Data))
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			attributes, ok := policy.tags[token.Data]
+			if !ok {
+				violation = true
+				if tt == html.StartTagToken && richTextDropContentTags[token.Data] {
+					skip = token.Data
+				}
+				continue
+			}
+			buf.WriteByte('<')
+			buf.WriteString(token.Data)
+			for _, attribute := range token.Attr {
+				if attribute.Namespace != "" || !attributes[attribute.Key] {
+					violation = true
+					continue
+				}
+				if richTextURLAttributes[attribute.Key] && !policy.isAllowedURL(attribute.Val) {
+					violation = true
+					continue
+				}
+				buf.WriteByte(' ')
+				buf.WriteString(attribute.Key)
+				buf.WriteString(`="`)
+				buf.WriteString(html.EscapeString(attribute.Val))
+				buf.WriteByte('"')
+			}
+			buf.WriteByte('>')
+			if tt == html.StartTagToken && !richTextVoidTags[token.Data] {
+				open = append(open, token.Data)
+			}
+
+		case html.EndTagToken:
+			// Close up to the matching open tag, ignore unmatched end tags.
+			for idx := len(open) - 1; idx >= 0; idx-- {
+				if open[idx] != token.Data {
+					continue
+				}
+				for len(open) > idx {
+					buf.WriteString("</" + open[len(open)-1] + ">")
+					open = open[:len(open)-1]
+				}
+				break
+			}
+
+		default:
+			// Comments, doctypes and everything else.
+			violation = true
+		}
+	}
+
+	// Close everything which is still open.
+	for idx := len(open) - 1; idx >= 0; idx-- {
+		buf.WriteString("</" + open[idx] + ">")
+	}
+
+	if violation && policy.reject {
+		return "", ErrRichTextRejected
+	}
+	return buf.String(), nil
+}
+
+// isAllowedURL returns true if the provided URL is absolute and has one of
+// the allowed schemes of the accociated policy.
+func (policy *RichTextPolicy) isAllowedURL(value string) bool {
+	u, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+
+	return policy.schemes[strings.ToLower(u.Scheme)]
+}
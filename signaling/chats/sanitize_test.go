@@ -0,0 +1,72 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chats
+
+import (
+	"testing"
+)
+
+func TestRichTextPolicySanitize(t *testing.T) {
+	policy, err := NewRichTextPolicy(RichTextPolicyModeClean, DefaultRichTextAllow, DefaultRichTextSchemes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in        string
+		out       string
+		violation bool
+	}{
+		{"plain &amp; simple", "plain &amp; simple", false},
+		{"<b>bold</b> and <i>italic</i>", "<b>bold</b> and <i>italic</i>", false},
+		{`<a href="https://kopano.io" title="Kopano">link</a>`, `<a href="https://kopano.io" title="Kopano">link</a>`, false},
+		{"line<br/>break", "line<br>break", false},
+		{"<b>unclosed", "<b>unclosed</b>", false},
+		{"<script>alert(1)</script>text", "text", true},
+		{`<img src=x onerror="alert(1)">`, "", true},
+		{`<b onclick="alert(1)">x</b>`, "<b>x</b>", true},
+		{`<a href="javascript:alert(1)">x</a>`, "<a>x</a>", true},
+		{`<a href="jav&#x09;ascript:alert(1)">x</a>`, "<a>x</a>", true},
+		{`<a href="/relative">x</a>`, "<a>x</a>", true},
+		{"<marquee>moving</marquee>", "moving", true},
+		{"<!-- comment -->text", "text", true},
+	}
+
+	reject, err := NewRichTextPolicy(RichTextPolicyModeReject, DefaultRichTextAllow, DefaultRichTextSchemes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		out, err := policy.Sanitize(test.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.in, err)
+		}
+		if out != test.out {
+			t.Errorf("%q: got %q, expected %q", test.in, out, test.out)
+		}
+
+		_, err = reject.Sanitize(test.in)
+		if test.violation && err != ErrRichTextRejected {
+			t.Errorf("%q: expected rejection, got %v", test.in, err)
+		}
+		if !test.violation && err != nil {
+			t.Errorf("%q: unexpected rejection: %v", test.in, err)
+		}
+	}
+}
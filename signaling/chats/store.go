@@ -0,0 +1,89 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chats
+
+import (
+	"context"
+	"errors"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+)
+
+// Errors as returned by stores.
+var (
+	ErrMessageNotFound = errors.New("message not found")
+)
+
+// History limits.
+const (
+	DefaultHistoryLimit = 50
+	MaximalHistoryLimit = 200
+)
+
+// A Store records chat messages per channel.
+type Store interface {
+	// Add records the provided record for the channel with the provided ID.
+	// Records for channels without matching retention rule are ignored.
+	Add(ctx context.Context, channel string, record *Record) error
+
+	// Get returns the record of the message with the provided ID of the
+	// channel with the provided ID. ErrMessageNotFound is returned if there is
+	// no such record.
+	Get(ctx context.Context, channel string, id string) (*Record, error)
+
+	// Update replaces the stored record of the message with the ID of the
+	// provided record's message. ErrMessageNotFound is returned if there is no
+	// such record.
+	Update(ctx context.Context, channel string, record *Record) error
+
+	// History returns the records of the channel with the provided ID as
+	// selected by the provided query, oldest first. The returned bool is true
+	// if older records are available.
+	History(ctx context.Context, channel string, query *HistoryQuery) ([]*Record, bool, error)
+
+	// Purge removes all records which exceed their retention limits.
+	Purge(ctx context.Context) error
+
+	// Close closes the store.
+	Close() error
+}
+
+// A Record is a stored chat message together with the profile of its sender
+// at the time the message was sent.
+type Record struct {
+	Message *api.RTMDataChatsMessage `json:"message"`
+	Profile *api.RTMDataProfile      `json:"profile,omitempty"`
+}
+
+// A HistoryQuery selects records of a channel. If Before is set, only records
+// older than the message with that ID are selected. If BeforeTS is set, only
+// records older than that unix timestamp are selected.
+type HistoryQuery struct {
+	Before   string
+	BeforeTS int64
+	Limit    int
+}
+
+// Normalize applies defaults and limits to the accociated query.
+func (query *HistoryQuery) Normalize() {
+	if query.Limit <= 0 {
+		query.Limit = DefaultHistoryLimit
+	} else if query.Limit > MaximalHistoryLimit {
+		query.Limit = MaximalHistoryLimit
+	}
+}
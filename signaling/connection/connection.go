@@ -53,12 +53,17 @@ type Connection struct {
 	logger logrus.FieldLogger
 
 	// TODO(longsleep): Make this a doubly link list.
-	send   chan []byte
-	mutex  sync.RWMutex
-	closed bool
+	send    chan SendRecord
+	mutex   sync.RWMutex
+	closed  bool
+	dropped bool
+
+	sendMutex  sync.Mutex
+	sendFilter SendFilterFunc
 
 	id       string
 	start    time.Time
+	active   time.Time
 	duration time.Duration
 	ping     chan *pingRecord
 
@@ -72,15 +77,17 @@ type Connection struct {
 
 // New creates a new Connection with the provided options and settings.
 func New(ctx context.Context, ws *websocket.Conn, mgr Manager, logger logrus.FieldLogger, id string) (*Connection, error) {
+	now := time.Now()
 	return &Connection{
 		ws:     ws,
 		mgr:    mgr,
 		logger: logger,
 		id:     id,
 
-		start: time.Now(),
-		send:  make(chan []byte, 256),
-		ping:  make(chan *pingRecord, 5),
+		start:  now,
+		active: now,
+		send:   make(chan SendRecord, 256),
+		ping:   make(chan *pingRecord, 5),
 
 		transactions: make(map[string]TransactionCallbackFunc),
 	}, nil
@@ -89,6 +96,21 @@ func New(ctx context.Context, ws *websocket.Conn, mgr Manager, logger logrus.Fie
 // ClosedFunc is a type for functions usable as closed callback.
 type ClosedFunc func(*Connection)
 
+// WrittenFunc is a type for functions usable as written callback.
+type WrittenFunc func(*Connection)
+
+// SendFilterFunc is a type for functions usable as send filter. A send filter
+// receives every payload before it is queued and returns the payloads which
+// are to be queued instead, in order.
+type SendFilterFunc func(*Connection, SendRecord) []SendRecord
+
+// SendRecord is a payload to be sent together with its optional written
+// callback.
+type SendRecord struct {
+	Payload []byte
+	Written WrittenFunc
+}
+
 // TransactionCallbackFunc is a tyoe for functions usable as transaction callback.
 type TransactionCallbackFunc func([]byte) error
 
@@ -145,6 +167,9 @@ func (c *Connection) readPump(ctx context.Context) error {
 		// Wait on incoming data from websocket.
 		op, r, err := c.ws.NextReader()
 		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.markDropped()
+			}
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
 				c.logger.WithError(err).Debugln("websocket read error")
 				return err
@@ -155,11 +180,16 @@ func (c *Connection) readPump(ctx context.Context) error {
 		// Process data based on op.
 		switch op {
 		case websocket.TextMessage:
+			c.mutex.Lock()
+			c.active = time.Now()
+			c.mutex.Unlock()
+
 			// TODO(longsleep): Reuse []byte, probably put into bytes.Buffer.
 			// Rread incoming message into memory.
 			var b []byte
 			b, err = ioutil.ReadAll(io.LimitReader(r, websocketMaxMessageSize))
 			if err != nil {
+				c.markDropped()
 				c.logger.Debugln("websocket read text error", err)
 				return err
 			}
@@ -204,18 +234,21 @@ func (c *Connection) writePump(ctx context.Context) error {
 			err = nil
 			return nil
 
-		case payload, ok := <-c.send:
-			if !ok || payload == nil {
+		case record, ok := <-c.send:
+			if !ok || record.Payload == nil {
 				c.logger.Debugln("websocket send channel closed or nil sent")
 				err = errors.New("send channel closed")
 				return nil
 			}
 
-			err = c.Write(payload, websocket.TextMessage)
+			err = c.Write(record.Payload, websocket.TextMessage)
 			if err != nil {
 				c.logger.WithError(err).Debugln("websocket write pump error")
 				return err
 			}
+			if record.Written != nil {
+				record.Written(c)
+			}
 
 		case <-ticker.C:
 			ping.id++
@@ -272,6 +305,26 @@ func (c *Connection) Close() error {
 	return nil
 }
 
+// markDropped flags the accociated connection as dropped, unless it was
+// already closed by us.
+func (c *Connection) markDropped() {
+	c.mutex.Lock()
+	if !c.closed {
+		c.dropped = true
+	}
+	c.mutex.Unlock()
+}
+
+// Dropped returns true if the accociated Connection went away without a clean
+// websocket close handshake, for example because of network errors.
+func (c *Connection) Dropped() bool {
+	c.mutex.RLock()
+	dropped := c.dropped
+	c.mutex.RUnlock()
+
+	return dropped
+}
+
 // OnClosed registers a callback to be caled after the connection has closed.
 func (c *Connection) OnClosed(cb ClosedFunc) {
 	c.mutex.Lock()
@@ -300,6 +353,16 @@ func (c *Connection) Duration() time.Duration {
 	return time.Since(c.start)
 }
 
+// LastActive returns the time when the accociated connection last received a
+// message from its client, or when it was created if it never received any.
+func (c *Connection) LastActive() time.Time {
+	c.mutex.RLock()
+	active := c.active
+	c.mutex.RUnlock()
+
+	return active
+}
+
 // Send encodes the provided message with JSON and then adds the encoded message
 // into the send queue in a non-blocking way.
 func (c *Connection) Send(message interface{}) error {
@@ -329,8 +392,36 @@ func (c *Connection) SendTransaction(message PayloadWithTransactionID, cb Transa
 }
 
 // RawSend adds the pprovided payload data into the send queue in a non blocking
-// way.
+// way. If a send filter is set, the payload is passed through it first.
 func (c *Connection) RawSend(payload []byte) error {
+	return c.RawSendWithWritten(payload, nil)
+}
+
+// RawSendWithWritten adds the provided payload data into the send queue in a
+// non blocking way like RawSend. The provided written callback is called from
+// the write pump, after the payload was successfully written to the websocket.
+// It is not called if the payload never gets written.
+func (c *Connection) RawSendWithWritten(payload []byte, written WrittenFunc) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	record := SendRecord{payload, written}
+	if c.sendFilter == nil {
+		return c.queue(record)
+	}
+
+	// NOTE(longsleep): The filter runs even for closed connections, so it can
+	// keep track of everything which was meant to be sent.
+	for _, filtered := range c.sendFilter(c, record) {
+		if err := c.queue(filtered); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Connection) queue(record SendRecord) error {
 	c.mutex.RLock()
 	if c.closed {
 		c.mutex.RUnlock()
@@ -338,7 +429,7 @@ func (c *Connection) RawSend(payload []byte) error {
 	}
 
 	select {
-	case c.send <- payload:
+	case c.send <- record:
 		// ok
 	default:
 		c.mutex.RUnlock()
@@ -351,6 +442,13 @@ func (c *Connection) RawSend(payload []byte) error {
 	return nil
 }
 
+// SetSendFilter sets the provided send filter for the accociated connection.
+func (c *Connection) SetSendFilter(filter SendFilterFunc) {
+	c.sendMutex.Lock()
+	c.sendFilter = filter
+	c.sendMutex.Unlock()
+}
+
 func (c *Connection) Write(payload []byte, messageType int) error {
 	c.ws.SetWriteDeadline(time.Now().Add(websocketWriteWait))
 
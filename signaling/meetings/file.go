@@ -0,0 +1,202 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package meetings
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FileStore is a Store which keeps all meetings in memory and persists them
+// as JSON into a file on every change.
+type FileStore struct {
+	sync.RWMutex
+
+	path     string
+	meetings map[string]*Meeting
+	logger   logrus.FieldLogger
+}
+
+type fileStoreData struct {
+	Meetings []*Meeting `json:"meetings"`
+}
+
+// NewFileStore loads the meetings from the file at the provided path and
+// returns a FileStore using it. The file is created on the first change if it
+// does not exist.
+func NewFileStore(ctx context.Context, path string, logger logrus.FieldLogger) (*FileStore, error) {
+	s := &FileStore{
+		path:     path,
+		meetings: make(map[string]*Meeting),
+		logger:   logger.WithField("meetings_store", "file"),
+	}
+
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		// Start empty.
+	case err != nil:
+		return nil, err
+	default:
+		defer f.Close()
+		var data fileStoreData
+		if err = json.NewDecoder(f).Decode(&data); err != nil {
+			return nil, err
+		}
+		for _, meeting := range data.Meetings {
+			s.meetings[meeting.ID] = meeting
+		}
+	}
+
+	s.logger.WithField("meetings", len(s.meetings)).Debugln("meetings loaded")
+	return s, nil
+}
+
+// save writes all meetings to the accociated file, replacing it atomically.
+// The caller must hold the write lock.
+func (s *FileStore) save() error {
+	data := &fileStoreData{
+		Meetings: make([]*Meeting, 0, len(s.meetings)),
+	}
+	for _, meeting := range s.meetings {
+		data.Meetings = append(data.Meetings, meeting)
+	}
+	sortMeetings(data.Meetings)
+
+	encoded, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), ".meetings-")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(encoded); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		s.logger.WithError(err).Errorln("failed to save meetings")
+	}
+
+	return err
+}
+
+// Add implements the Store interface.
+func (s *FileStore) Add(ctx context.Context, meeting *Meeting) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, exists := s.meetings[meeting.ID]; exists {
+		return ErrMeetingExists
+	}
+	copied := *meeting
+	s.meetings[meeting.ID] = &copied
+
+	err := s.save()
+	if err != nil {
+		delete(s.meetings, meeting.ID)
+	}
+	return err
+}
+
+// Get implements the Store interface.
+func (s *FileStore) Get(ctx context.Context, id string) (*Meeting, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	meeting, exists := s.meetings[id]
+	if !exists {
+		return nil, ErrMeetingNotFound
+	}
+	copied := *meeting
+	return &copied, nil
+}
+
+// Update implements the Store interface.
+func (s *FileStore) Update(ctx context.Context, meeting *Meeting) error {
+	s.Lock()
+	defer s.Unlock()
+
+	existing, exists := s.meetings[meeting.ID]
+	if !exists {
+		return ErrMeetingNotFound
+	}
+	copied := *meeting
+	s.meetings[meeting.ID] = &copied
+
+	err := s.save()
+	if err != nil {
+		s.meetings[meeting.ID] = existing
+	}
+	return err
+}
+
+// Remove implements the Store interface.
+func (s *FileStore) Remove(ctx context.Context, id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	existing, exists := s.meetings[id]
+	if !exists {
+		return ErrMeetingNotFound
+	}
+	delete(s.meetings, id)
+
+	err := s.save()
+	if err != nil {
+		s.meetings[id] = existing
+	}
+	return err
+}
+
+// List implements the Store interface.
+func (s *FileStore) List(ctx context.Context, query *ListQuery) ([]*Meeting, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	meetings := make([]*Meeting, 0)
+	for _, meeting := range s.meetings {
+		if query != nil && query.Group != "" && meeting.Group != query.Group {
+			continue
+		}
+		copied := *meeting
+		meetings = append(meetings, &copied)
+	}
+	sortMeetings(meetings)
+
+	return meetings, nil
+}
+
+// Close implements the Store interface.
+func (s *FileStore) Close() error {
+	return nil
+}
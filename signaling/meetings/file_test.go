@@ -0,0 +1,123 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package meetings
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "kwmserver-meetings-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "meetings.json")
+
+	store, err := NewFileStore(ctx, path, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, meeting := range []*Meeting{
+		{ID: "b", Group: "group1", Start: 200, End: 300},
+		{ID: "a", Group: "group1", Start: 100, End: 200},
+		{ID: "c", Group: "group2", Start: 100, End: 200},
+	} {
+		if err = store.Add(ctx, meeting); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = store.Add(ctx, &Meeting{ID: "a"}); err != ErrMeetingExists {
+		t.Errorf("expected meeting exists error, got %v", err)
+	}
+	if err = store.Remove(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Update(ctx, &Meeting{ID: "c"}); err != ErrMeetingNotFound {
+		t.Errorf("expected meeting not found error, got %v", err)
+	}
+
+	// Reload from file.
+	store, err = NewFileStore(ctx, path, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meetings, err := store.List(ctx, &ListQuery{Group: "group1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meetings) != 2 || meetings[0].ID != "a" || meetings[1].ID != "b" {
+		t.Errorf("unexpected meetings %+v", meetings)
+	}
+	if active := FindActive(meetings, time.Unix(250, 0)); active == nil || active.ID != "b" {
+		t.Errorf("unexpected active meeting %+v", active)
+	}
+	if _, err = store.Get(ctx, "c"); err != ErrMeetingNotFound {
+		t.Errorf("expected meeting not found error, got %v", err)
+	}
+}
+
+func TestMeetingAllows(t *testing.T) {
+	user := &api.AdminAuthToken{
+		Claims: map[string]interface{}{
+			"department": "sales",
+			"groups":     []interface{}{"a", "b"},
+		},
+	}
+	guest := &api.AdminAuthToken{
+		GroupRestriction: map[string]bool{"group1": true},
+	}
+
+	tests := []struct {
+		meeting *Meeting
+		id      string
+		auth    *api.AdminAuthToken
+		allowed bool
+	}{
+		{&Meeting{}, "user1", user, true},
+		{&Meeting{Users: []string{"user1"}}, "user1", user, true},
+		{&Meeting{Users: []string{"user2"}}, "user1", user, false},
+		{&Meeting{Claims: map[string]string{"department": "sales"}}, "user1", user, true},
+		{&Meeting{Claims: map[string]string{"groups": "b"}}, "user1", user, true},
+		{&Meeting{Claims: map[string]string{"groups": "c"}}, "user1", user, false},
+		{&Meeting{Claims: map[string]string{"groups": "a"}}, "user1", nil, false},
+		{&Meeting{}, "guest1", guest, true},
+		{&Meeting{Users: []string{"user2"}}, "guest1", guest, false},
+		{&Meeting{Claims: map[string]string{"groups": "a"}}, "guest1", guest, false},
+		{&Meeting{Users: []string{"user2"}, Guests: GuestPolicyAllow}, "guest1", guest, true},
+		{&Meeting{Guests: GuestPolicyDeny}, "guest1", guest, false},
+	}
+
+	for idx, test := range tests {
+		if allowed := test.meeting.Allows(test.id, test.auth); allowed != test.allowed {
+			t.Errorf("%d: allowed %v does not match expected %v", idx, allowed, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package meetings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+)
+
+// Errors as returned by stores.
+var (
+	ErrMeetingNotFound = errors.New("meeting not found")
+	ErrMeetingExists   = errors.New("meeting already exists")
+)
+
+// Guest policies of meetings.
+const (
+	GuestPolicyAllow = "allow"
+	GuestPolicyDeny  = "deny"
+)
+
+// A Store holds scheduled meetings.
+type Store interface {
+	// Add adds the provided meeting. ErrMeetingExists is returned if there is
+	// already a meeting with the same ID.
+	Add(ctx context.Context, meeting *Meeting) error
+
+	// Get returns the meeting with the provided ID. ErrMeetingNotFound is
+	// returned if there is no such meeting.
+	Get(ctx context.Context, id string) (*Meeting, error)
+
+	// Update replaces the stored meeting with the ID of the provided meeting.
+	// ErrMeetingNotFound is returned if there is no such meeting.
+	Update(ctx context.Context, meeting *Meeting) error
+
+	// Remove removes the meeting with the provided ID. ErrMeetingNotFound is
+	// returned if there is no such meeting.
+	Remove(ctx context.Context, id string) error
+
+	// List returns the meetings selected by the provided query, sorted by
+	// their start.
+	List(ctx context.Context, query *ListQuery) ([]*Meeting, error)
+
+	// Close closes the store.
+	Close() error
+}
+
+// A ListQuery selects meetings. If Group is set, only meetings of that group
+// are selected.
+type ListQuery struct {
+	Group string
+}
+
+// A Meeting is a scheduled meeting in a named group. Joins to the group are
+// only possible between Start and End, which are unix timestamps. If Users is
+// set, only the listed users can join. If Claims is set, only users with all
+// listed claim values can join. Guests can join if the guest policy is
+// GuestPolicyAllow. Without guest policy, guests can only join meetings which
+// have neither Users nor Claims set. If MaxParticipants is set, it limits the
+// number of participants of the meeting.
+type Meeting struct {
+	ID    string `json:"id"`
+	Group string `json:"group"`
+	Title string `json:"title,omitempty"`
+
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+
+	Users  []string          `json:"users,omitempty"`
+	Claims map[string]string `json:"claims,omitempty"`
+	Guests string            `json:"guests,omitempty"`
+
+	MaxParticipants int `json:"max_participants,omitempty"`
+
+	Created int64 `json:"created,omitempty"`
+	Updated int64 `json:"updated,omitempty"`
+}
+
+// Validate checks the accociated meeting for consistency.
+func (meeting *Meeting) Validate() error {
+	if meeting.Group == "" {
+		return errors.New("group is empty")
+	}
+	if meeting.Start <= 0 || meeting.End <= meeting.Start {
+		return errors.New("invalid start or end")
+	}
+	switch meeting.Guests {
+	case "", GuestPolicyAllow, GuestPolicyDeny:
+	default:
+		return fmt.Errorf("invalid guest policy: %v", meeting.Guests)
+	}
+	if meeting.MaxParticipants < 0 {
+		return errors.New("invalid max participants")
+	}
+
+	return nil
+}
+
+// IsActive returns true if the provided time is within the window of the
+// accociated meeting.
+func (meeting *Meeting) IsActive(now time.Time) bool {
+	ts := now.Unix()
+	return ts >= meeting.Start && ts < meeting.End
+}
+
+// Allows returns true if the user with the provided ID and auth is allowed to
+// join the accociated meeting.
+func (meeting *Meeting) Allows(id string, auth *api.AdminAuthToken) bool {
+	if auth != nil && auth.IsGuest() {
+		switch meeting.Guests {
+		case GuestPolicyAllow:
+			return true
+		case GuestPolicyDeny:
+			return false
+		default:
+			return len(meeting.Users) == 0 && len(meeting.Claims) == 0
+		}
+	}
+
+	if len(meeting.Users) > 0 {
+		found := false
+		for _, user := range meeting.Users {
+			if user == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for claim, value := range meeting.Claims {
+		if auth == nil || !hasClaimValue(auth.Claims[claim], value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasClaimValue returns true if the provided claim is the provided value or a
+// list containing the provided value.
+func hasClaimValue(claim interface{}, value string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == value
+	case []interface{}:
+		for _, entry := range v {
+			if s, _ := entry.(string); s == value {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// FindActive returns the first of the provided meetings which is active at
+// the provided time, or nil if none is.
+func FindActive(meetings []*Meeting, now time.Time) *Meeting {
+	for _, meeting := range meetings {
+		if meeting.IsActive(now) {
+			return meeting
+		}
+	}
+
+	return nil
+}
+
+func sortMeetings(meetings []*Meeting) {
+	sort.Slice(meetings, func(i, j int) bool {
+		if meetings[i].Start == meetings[j].Start {
+			return meetings[i].ID < meetings[j].ID
+		}
+		return meetings[i].Start < meetings[j].Start
+	})
+}
@@ -0,0 +1,327 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+const (
+	maxAdminRequestSize = 1024 * 5
+)
+
+// AddAdminRoutes adds the RTM admin HTTP routes to the provided router,
+// wrapped with the provided wrapper.
+func (m *Manager) AddAdminRoutes(ctx context.Context, router *mux.Router, wrapper func(http.Handler) http.Handler) http.Handler {
+	router.Handle("/groups/{group}/passcode", wrapper(http.HandlerFunc(m.setGroupPasscodeHandler))).Methods(http.MethodPut)
+	router.Handle("/groups/{group}/passcode", wrapper(http.HandlerFunc(m.removeGroupPasscodeHandler))).Methods(http.MethodDelete)
+
+	router.Handle("/channels", wrapper(http.HandlerFunc(m.listChannelsHandler))).Methods(http.MethodGet)
+	router.Handle("/channels/{id}", wrapper(http.HandlerFunc(m.getChannelHandler))).Methods(http.MethodGet)
+	router.Handle("/channels/{id}", wrapper(http.HandlerFunc(m.removeChannelHandler))).Methods(http.MethodDelete)
+	router.Handle("/drain", wrapper(http.HandlerFunc(m.drainHandler))).Methods(http.MethodPost)
+	router.Handle("/notices", wrapper(http.HandlerFunc(m.listServerNoticesHandler))).Methods(http.MethodGet)
+	router.Handle("/notices", wrapper(http.HandlerFunc(m.addServerNoticeHandler))).Methods(http.MethodPost)
+	router.Handle("/notices/{id}", wrapper(http.HandlerFunc(m.removeServerNoticeHandler))).Methods(http.MethodDelete)
+	router.Handle("/users", wrapper(http.HandlerFunc(m.listUsersHandler))).Methods(http.MethodGet)
+	router.Handle("/connections/{id}", wrapper(http.HandlerFunc(m.getConnectionHandler))).Methods(http.MethodGet)
+	router.Handle("/connections/{id}", wrapper(http.HandlerFunc(m.removeConnectionHandler))).Methods(http.MethodDelete)
+
+	return router
+}
+
+func (m *Manager) setGroupPasscodeHandler(rw http.ResponseWriter, req *http.Request) {
+	msg, err := ioutil.ReadAll(io.LimitReader(req.Body, maxAdminRequestSize))
+	if err != nil {
+		m.logger.WithError(err).Debugln("failed to read request body")
+		http.Error(rw, fmt.Errorf("failed to read request: %v", err).Error(), http.StatusBadRequest)
+		return
+	}
+
+	var data api.RTMDataWebRTCPasscode
+	err = json.Unmarshal(msg, &data)
+	if err != nil {
+		m.logger.WithError(err).Debugln("failed to parse request")
+		http.Error(rw, fmt.Errorf("failed to parse: %v", err).Error(), http.StatusBadRequest)
+		return
+	}
+	if data.Passcode == "" {
+		http.Error(rw, fmt.Errorf("passcode cannot be empty").Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = m.SetGroupPasscode(mux.Vars(req)["group"], data.Passcode)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Manager) removeGroupPasscodeHandler(rw http.ResponseWriter, req *http.Request) {
+	group := mux.Vars(req)["group"]
+	if _, exists := m.groupPasscodes.Pop(group); !exists {
+		http.NotFound(rw, req)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func writeAdminResponse(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	encoder := json.NewEncoder(rw)
+	encoder.SetIndent("", "\t")
+	encoder.Encode(v)
+}
+
+func (m *Manager) getAdminChannel(record *channelRecord) *api.AdminRTMChannel {
+	channel := record.channel
+	members, _ := channel.Connections()
+	sort.Strings(members)
+
+	result := &api.AdminRTMChannel{
+		ID:      channel.id,
+		Group:   channel.config.Group,
+		Members: members,
+		Created: record.when.Unix(),
+	}
+	channel.RLock()
+	result.Lobby = len(channel.lobby)
+	channel.RUnlock()
+	if pipeline := channel.Pipeline(); pipeline != nil {
+		result.PipelineMode = pipeline.Mode()
+	}
+
+	return result
+}
+
+func (m *Manager) listChannelsHandler(rw http.ResponseWriter, req *http.Request) {
+	result := make([]*api.AdminRTMChannel, 0)
+	for entry := range m.channels.IterBuffered() {
+		result = append(result, m.getAdminChannel(entry.Val.(*channelRecord)))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	writeAdminResponse(rw, http.StatusOK, result)
+}
+
+func (m *Manager) getChannelHandler(rw http.ResponseWriter, req *http.Request) {
+	record, ok := m.channels.Get(mux.Vars(req)["id"])
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+
+	writeAdminResponse(rw, http.StatusOK, m.getAdminChannel(record.(*channelRecord)))
+}
+
+func (m *Manager) removeChannelHandler(rw http.ResponseWriter, req *http.Request) {
+	record, ok := m.channels.Get(mux.Vars(req)["id"])
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+
+	m.teardownChannel(record.(*channelRecord).channel, req.URL.Query().Get("reason"))
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// teardownChannel removes everyone from the provided channel, letting them
+// know the provided reason, and then removes the channel.
+func (m *Manager) teardownChannel(channel *Channel, reason string) {
+	channel.RLock()
+	waiting := make([]string, 0, len(channel.lobby))
+	for id := range channel.lobby {
+		waiting = append(waiting, id)
+	}
+	channel.RUnlock()
+	for _, id := range waiting {
+		if entry := channel.takeGroupLobbyEntry(id, false); entry != nil {
+			m.sendGroupLobbyState(channel, entry, api.RTMLobbyStateDenied)
+		}
+	}
+
+	data, _ := json.MarshalIndent(&api.RTMDataWebRTCModeration{
+		Reason: reason,
+	}, "", "\t")
+	members, connections := channel.Connections()
+	for idx, id := range members {
+		err := connections[idx].Send(&api.RTMTypeWebRTC{
+			RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+				Type:    api.RTMTypeNameWebRTC,
+				Subtype: api.RTMSubtypeNameWebRTCKick,
+			},
+			Target:  id,
+			Channel: channel.id,
+			Group:   channel.config.Group,
+			Version: currentWebRTCPayloadVersion,
+			Data:    data,
+		})
+		if err != nil {
+			connections[idx].Logger().WithError(err).WithField("channel", channel.id).Debugln("failed to send channel teardown")
+		}
+		channel.Remove(id)
+	}
+
+	if channel.Cleanup() {
+		m.channels.Remove(channel.id)
+		if group := channel.config.Group; group != "" {
+			m.removeModeratorGroupPasscode(group)
+		}
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"channel": channel.id,
+		"members": len(members),
+		"reason":  reason,
+	}).Infoln("channel torn down by admin")
+}
+
+func (m *Manager) listUsersHandler(rw http.ResponseWriter, req *http.Request) {
+	now := time.Now()
+	result := make([]*api.AdminRTMUser, 0)
+	for entry := range m.users.IterBuffered() {
+		ur := entry.Val.(*userRecord)
+		user := &api.AdminRTMUser{
+			ID: ur.id,
+		}
+		if ur.auth != nil {
+			user.Name = ur.auth.Name()
+			user.Guest = ur.auth.IsGuest()
+		}
+		ur.RLock()
+		user.Connections = make([]string, 0, len(ur.connections))
+		for _, c := range ur.connections {
+			user.Connections = append(user.Connections, c.ID())
+		}
+		user.Since = ur.when.Unix()
+		user.Duration = int64(now.Sub(ur.when) / time.Second)
+		ur.RUnlock()
+		result = append(result, user)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	writeAdminResponse(rw, http.StatusOK, result)
+}
+
+func (m *Manager) getConnectionHandler(rw http.ResponseWriter, req *http.Request) {
+	record, ok := m.connections.Get(mux.Vars(req)["id"])
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	c := record.(*connection.Connection)
+
+	result := &api.AdminRTMConnection{
+		ID:         c.ID(),
+		Duration:   int64(c.Duration() / time.Second),
+		LastActive: c.LastActive().Unix(),
+		Channels:   make([]string, 0),
+	}
+	if ur, _ := c.Bound().(*userRecord); ur != nil {
+		result.User = ur.id
+		for entry := range m.channels.IterBuffered() {
+			channel := entry.Val.(*channelRecord).channel
+			if cc, _ := channel.Get(ur.id); cc == c {
+				result.Channels = append(result.Channels, channel.id)
+			}
+		}
+		sort.Strings(result.Channels)
+	}
+
+	writeAdminResponse(rw, http.StatusOK, result)
+}
+
+func (m *Manager) removeConnectionHandler(rw http.ResponseWriter, req *http.Request) {
+	record, ok := m.connections.Get(mux.Vars(req)["id"])
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	c := record.(*connection.Connection)
+	reason := req.URL.Query().Get("reason")
+
+	c.Logger().WithField("reason", reason).Infoln("websocket rtm closed by admin")
+	c.Send(&api.RTMTypeHello{
+		Type:    api.RTMTypeNameGoodbye,
+		Reason:  api.RTMGoodbyeReasonAdmin,
+		Message: reason,
+	})
+	c.Close()
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Manager) listServerNoticesHandler(rw http.ResponseWriter, req *http.Request) {
+	writeAdminResponse(rw, http.StatusOK, m.getServerNotices())
+}
+
+func (m *Manager) addServerNoticeHandler(rw http.ResponseWriter, req *http.Request) {
+	msg, err := ioutil.ReadAll(io.LimitReader(req.Body, maxAdminRequestSize))
+	if err != nil {
+		m.logger.WithError(err).Debugln("failed to read request body")
+		http.Error(rw, fmt.Errorf("failed to read request: %v", err).Error(), http.StatusBadRequest)
+		return
+	}
+
+	var notice api.AdminServerNotice
+	err = json.Unmarshal(msg, &notice)
+	if err != nil {
+		m.logger.WithError(err).Debugln("failed to parse request")
+		http.Error(rw, fmt.Errorf("failed to parse: %v", err).Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = m.AddServerNotice(&notice)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeAdminResponse(rw, http.StatusCreated, &notice)
+}
+
+func (m *Manager) removeServerNoticeHandler(rw http.ResponseWriter, req *http.Request) {
+	if !m.RemoveServerNotice(mux.Vars(req)["id"]) {
+		http.NotFound(rw, req)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
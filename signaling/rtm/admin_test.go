@@ -0,0 +1,81 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"stash.kopano.io/kwm/kwmserver/signaling/admin"
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+)
+
+func TestAdminRoutesRequireAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	adminm := admin.NewManager(ctx, "", newTestLogger())
+	adminm.AddTokenKey("", []byte("test-key"))
+	token := &api.AdminAuthToken{
+		Type:      api.AdminAuthTokenTypeToken,
+		Subject:   "admin",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+	tokenValue, err := adminm.SignAdminAuthToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestManager(ctx)
+	router := mux.NewRouter()
+	m.AddAdminRoutes(ctx, router, adminm.RequireAuth)
+
+	tests := []struct {
+		method string
+		url    string
+		auth   bool
+		status int
+	}{
+		{http.MethodGet, "/users", false, http.StatusForbidden},
+		{http.MethodGet, "/users", true, http.StatusOK},
+		{http.MethodDelete, "/channels/unknown", false, http.StatusForbidden},
+		{http.MethodDelete, "/channels/unknown", true, http.StatusNotFound},
+		{http.MethodDelete, "/connections/unknown", false, http.StatusForbidden},
+		{http.MethodDelete, "/connections/unknown", true, http.StatusNotFound},
+		{http.MethodPost, "/drain", false, http.StatusForbidden},
+		{http.MethodPost, "/drain", true, http.StatusNotImplemented},
+	}
+
+	for idx, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		if test.auth {
+			req.Header.Set("Authorization", "Token "+tokenValue)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("%d: %s %s returned status %v, expected %v", idx, test.method, test.url, rr.Code, test.status)
+		}
+	}
+}
@@ -23,11 +23,13 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"stash.kopano.io/kgol/rndm"
 
 	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/chats"
 	"stash.kopano.io/kwm/kwmserver/signaling/connection"
 	"stash.kopano.io/kwm/kwmserver/signaling/mcu"
 )
@@ -67,12 +69,35 @@ type Channel struct {
 	connections map[string]*connection.Connection
 	mutex       map[string]*sync.Mutex
 
+	typing     map[string]*channelTyping
+	chats      map[string]*chats.Record
+	chatsOrder []string
+
+	receipts      map[string]*chatsReceipt
+	receiptsOrder []string
+
+	moderators map[string]bool
+	blocked    map[string]time.Time
+
+	lobby    map[string]*lobbyEntry
+	admitted map[string]bool
+
+	versions map[string]uint64
+	states   map[string]*api.RTMDataWebRTCState
+
+	ring *callRing
+
 	pipeline Pipeline
 }
 
+// ErrChannelFull is the error returned when adding to a channel which has
+// reached its maximum number of participants.
+var ErrChannelFull = errors.New("channel is full")
+
 // ChannelConfig adds extra configuration for a Channel.
 type ChannelConfig struct {
-	Group string
+	Group           string
+	MaxParticipants int
 
 	Replace          func(channel *Channel, cid string, oldConn *connection.Connection, newConn *connection.Connection)
 	AfterAddOrRemove func(channel *Channel, op ChannelOp, cid string)
@@ -98,6 +123,20 @@ func NewChannel(id string, m *Manager, logger logrus.FieldLogger, config *Channe
 		mutex: map[string]*sync.Mutex{
 			channelMutexChats: {},
 		},
+
+		typing: make(map[string]*channelTyping),
+		chats:  make(map[string]*chats.Record),
+
+		receipts: make(map[string]*chatsReceipt),
+
+		moderators: make(map[string]bool),
+		blocked:    make(map[string]time.Time),
+
+		lobby:    make(map[string]*lobbyEntry),
+		admitted: make(map[string]bool),
+
+		versions: make(map[string]uint64),
+		states:   make(map[string]*api.RTMDataWebRTCState),
 	}
 	channel.logger.Debugln("channel create")
 	channelNew.WithLabelValues(m.id).Inc()
@@ -187,6 +226,10 @@ func (c *Channel) Add(id string, conn *connection.Connection) error {
 	}
 
 	existingConn, replacing := c.connections[id]
+	if !replacing && c.isFull() {
+		c.Unlock()
+		return ErrChannelFull
+	}
 	if replacing {
 		if conn == existingConn {
 			// Nothing to do, added conn is already added.
@@ -209,11 +252,7 @@ func (c *Channel) Add(id string, conn *connection.Connection) error {
 	c.connections[id] = conn
 	c.Unlock()
 
-	conn.OnClosed(func(connection *connection.Connection) {
-		c.Lock()
-		c.remove(id, conn) // Remove exact matches only.
-		c.Unlock()
-	})
+	conn.OnClosed(c.onConnectionClosed(id))
 
 	c.logger.WithFields(logrus.Fields{
 		"id":      id,
@@ -231,6 +270,43 @@ func (c *Channel) Add(id string, conn *connection.Connection) error {
 	return nil
 }
 
+// reattach replaces the connection identified by id with the provided new
+// connection, if the current connection is the provided old connection. Unlike
+// Add, this does not trigger any add or remove handlers.
+func (c *Channel) reattach(id string, oldConn *connection.Connection, newConn *connection.Connection) error {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return errors.New("channel is closed")
+	}
+	if existingConn := c.connections[id]; existingConn != oldConn {
+		c.Unlock()
+		return errors.New("conn does not match")
+	}
+	c.connections[id] = newConn
+	c.Unlock()
+
+	newConn.OnClosed(c.onConnectionClosed(id))
+
+	c.logger.WithFields(logrus.Fields{
+		"id":      id,
+		"channel": c.id,
+	}).Debugln("channel reattach")
+	return nil
+}
+
+func (c *Channel) onConnectionClosed(id string) connection.ClosedFunc {
+	return func(conn *connection.Connection) {
+		if c.m.holdSessionMembership(conn, c, id) {
+			// Keep membership, the connection's session might get resumed.
+			return
+		}
+		c.Lock()
+		c.remove(id, conn) // Remove exact matches only.
+		c.Unlock()
+	}
+}
+
 // Remove removes the connection identified by the provided id.
 func (c *Channel) Remove(id string) error {
 	c.Lock()
@@ -247,6 +323,12 @@ func (c *Channel) remove(id string, conn *connection.Connection) error {
 		}
 	}
 	delete(c.connections, id)
+	delete(c.versions, id)
+	delete(c.states, id)
+	if c.moderators[id] {
+		delete(c.moderators, id)
+		c.passModerator()
+	}
 	c.logger.WithFields(logrus.Fields{
 		"id":      id,
 		"channel": c.id,
@@ -255,6 +337,9 @@ func (c *Channel) remove(id string, conn *connection.Connection) error {
 
 	if existingConn != nil {
 		go c.m.emitChannelChatsAddOrRemove(existingConn, c, ChannelOpRemove, id)
+		if c.m.IsDraining() {
+			go c.m.drainConnectionIfIdle(existingConn)
+		}
 	}
 
 	if c.config.AfterAddOrRemove != nil {
@@ -263,6 +348,20 @@ func (c *Channel) remove(id string, conn *connection.Connection) error {
 	return nil
 }
 
+// IsFull returns true if the channel has reached its maximum number of
+// participants.
+func (c *Channel) IsFull() bool {
+	c.RLock()
+	full := c.isFull()
+	c.RUnlock()
+
+	return full
+}
+
+func (c *Channel) isFull() bool {
+	return c.config.MaxParticipants > 0 && len(c.connections) >= c.config.MaxParticipants
+}
+
 // Get retrieves the connection identified by the provided id.
 func (c *Channel) Get(id string) (*connection.Connection, bool) {
 	c.RLock()
@@ -295,8 +394,9 @@ func (c *Channel) CanBeCleanedUp() bool {
 func (c *Channel) canBeCleanedUp() bool {
 	size := len(c.connections)
 	if c.config != nil {
-		// Special channels clean up when no connections remain.
-		return size <= 0
+		// Special channels clean up when no connections remain and nobody is
+		// waiting in the lobby.
+		return size <= 0 && len(c.lobby) == 0
 	}
 
 	// Normal channels can be cleaned up when only a single connection is set.
@@ -315,6 +415,10 @@ func (c *Channel) Cleanup() bool {
 	pipeline := c.pipeline
 	c.closed = true
 	c.pipeline = nil
+	if c.ring != nil {
+		c.ring.stop()
+		c.ring = nil
+	}
 	size := len(c.connections)
 	c.Unlock()
 
@@ -19,12 +19,14 @@ package rtm
 
 import (
 	"encoding/json"
+	"errors"
 	"strings"
 	"time"
 
 	"stash.kopano.io/kgol/rndm"
 
 	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/chats"
 	"stash.kopano.io/kwm/kwmserver/signaling/connection"
 )
 
@@ -42,6 +44,10 @@ const currentChatsPayloadVersion uint64 = 20201201
 const maximalChatsTextMessageSize = 1 << 14
 const maximalChatsRichTextMessageSize = maximalChatsTextMessageSize + 1638 // Allow a little extra for rich text
 
+// maximalChatsMessageTargets is the maximum number of targets of a private
+// chats message.
+const maximalChatsMessageTargets = 100
+
 func (m *Manager) onChats(c *connection.Connection, msg *api.RTMTypeChats) error {
 	processErr := m.processChatsMessage(c, msg)
 
@@ -63,6 +69,100 @@ func (m *Manager) validateRestrictedChatsMessage(c *connection.Connection, msg *
 	return nil, nil
 }
 
+// validateChatsUserText validates and trims the text of the provided user
+// generated text message.
+func (m *Manager) validateChatsUserText(extra *api.RTMDataChatsMessage, msg *api.RTMTypeChats) error {
+	// Limit message size.
+	if len(extra.Text) > maximalChatsTextMessageSize {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message text size limit exceeded", msg.ID)
+	}
+	if len(extra.RichText) > maximalChatsRichTextMessageSize {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message rich text size limit exceeded", msg.ID)
+	}
+	// Trim spaces.
+	extra.Text = strings.TrimSpace(extra.Text)
+	if extra.Text == "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message text is empty", msg.ID)
+	}
+	extra.RichText = strings.TrimSpace(extra.RichText)
+
+	// Sanitize rich text, it is rendered as HTML by receiving clients.
+	if extra.RichText != "" && m.chatsRichTextPolicy != nil {
+		richText, err := m.chatsRichTextPolicy.Sanitize(extra.RichText)
+		if err != nil {
+			if err == chats.ErrRichTextRejected {
+				return api.NewRTMTypeError(api.RTMErrorIDRichTextRejected, "message rich text rejected by policy", msg.ID)
+			}
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message rich text parse error", msg.ID)
+		}
+		extra.RichText = richText
+	}
+
+	return nil
+}
+
+// normalizeChatsMessageTargets combines the target and targets of the provided
+// message into its targets and marks the message as private if it has any. It
+// returns the set of targets, or nil if the message is for everyone.
+func normalizeChatsMessageTargets(extra *api.RTMDataChatsMessage, sender string) (map[string]bool, error) {
+	if extra.Target == "" && len(extra.Targets) == 0 {
+		return nil, nil
+	}
+	if len(extra.Targets) > maximalChatsMessageTargets {
+		return nil, errors.New("message targets limit exceeded")
+	}
+
+	targets := make(map[string]bool)
+	normalized := make([]string, 0, len(extra.Targets)+1)
+	for _, target := range append([]string{extra.Target}, extra.Targets...) {
+		if target == "" || targets[target] {
+			continue
+		}
+		if target == sender {
+			return nil, errors.New("message target must not be sender")
+		}
+		targets[target] = true
+		normalized = append(normalized, target)
+	}
+
+	extra.Targets = normalized
+	if len(normalized) == 1 {
+		extra.Target = normalized[0]
+	} else {
+		extra.Target = ""
+	}
+	extra.Private = true
+
+	return targets, nil
+}
+
+// getChatsChannel returns the channel of the provided chats message, ensuring
+// that the provided connection is in that channel.
+func (m *Manager) getChatsChannel(c *connection.Connection, msg *api.RTMTypeChats, ur *userRecord) (*Channel, error) {
+	// Connection must have a user.
+	if ur == nil {
+		return nil, api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection has no user", msg.ID)
+	}
+	// Channel must not be empty.
+	if msg.Channel == "" {
+		return nil, api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel is empty", msg.ID)
+	}
+
+	// Get channel
+	record, ok := m.channels.Get(msg.Channel)
+	if !ok {
+		return nil, api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel not found", msg.ID)
+	}
+	channel := record.(*channelRecord).channel
+
+	// Receiving connection must be in channel.
+	if cc, _ := channel.Get(ur.id); cc != c {
+		return nil, api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection not in channel", msg.ID)
+	}
+
+	return channel, nil
+}
+
 func (m *Manager) processChatsMessage(c *connection.Connection, msg *api.RTMTypeChats) error {
 	if msg.Version < minimalChatsPayloadVersion {
 		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "outdated Chats payload version", msg.ID)
@@ -109,26 +209,21 @@ func (m *Manager) processChatsMessage(c *connection.Connection, msg *api.RTMType
 		if extra.Sender != "" {
 			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message sender must be empty", msg.ID)
 		}
-		// Message target must be empty (means everyone in the channel)
-		if extra.Target != "" {
-			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message target must be empty", msg.ID)
+		// Message targets, empty means everyone in the channel. Messages with
+		// targets are private and delivered to the targets only.
+		if extra.Private {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message private flag must not be set", msg.ID)
+		}
+		targets, targetsErr := normalizeChatsMessageTargets(extra, ur.id)
+		if targetsErr != nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, targetsErr.Error(), msg.ID)
 		}
 
 		switch extra.Kind {
 		case api.RTMChatsMessageKindMessageUserText: // Normal user generated text message.
-			// Limit message size.
-			if len(extra.Text) > maximalChatsTextMessageSize {
-				return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message text size limit exceeded", msg.ID)
-			}
-			if len(extra.RichText) > maximalChatsRichTextMessageSize {
-				return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message rich text size limit exceeded", msg.ID)
+			if err = m.validateChatsUserText(extra, msg); err != nil {
+				return err
 			}
-			// Trim spaces.
-			extra.Text = strings.TrimSpace(extra.Text)
-			if extra.Text == "" {
-				return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message text is empty", msg.ID)
-			}
-			extra.RichText = strings.TrimSpace(extra.RichText)
 			// Do not allow extra data in user generated text messages.
 			if extra.Extra != nil {
 				return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message contains unexpected extra data", msg.ID)
@@ -178,6 +273,23 @@ func (m *Manager) processChatsMessage(c *connection.Connection, msg *api.RTMType
 			channel.namedMutexLock(channelMutexChats)
 			defer channel.namedMutexUnlock(channelMutexChats)
 
+			// Sending a message ends typing.
+			m.clearChannelChatsTyping(channel, ur.id)
+
+			members, connections := channel.Connections()
+			if targets != nil {
+				// All targets must be members of the channel.
+				memberSet := make(map[string]bool, len(members))
+				for _, member := range members {
+					memberSet[member] = true
+				}
+				for target := range targets {
+					if !memberSet[target] {
+						return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message target not in channel", msg.ID)
+					}
+				}
+			}
+
 			// Send to self to let sender know id of the new message.
 			sendErr := c.Send(&api.RTMTypeChatsReply{
 				RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
@@ -194,19 +306,57 @@ func (m *Manager) processChatsMessage(c *connection.Connection, msg *api.RTMType
 				return sendErr
 			}
 
-			// Loop through channel connections, sending out payload.
-			_, connections := channel.Connections()
-			for _, connection := range connections {
+			// Collect recipients, to track their receipts.
+			recipients := make([]int, 0, len(connections))
+			recipientIDs := make([]string, 0, len(connections))
+			for idx, connection := range connections {
 				if connection == c {
 					// Skip sending message to sender (self).
 					continue
 				}
-				err = connection.RawSend(payload)
+				if targets != nil && !targets[members[idx]] {
+					// Skip sending private message to others.
+					continue
+				}
+				recipients = append(recipients, idx)
+				recipientIDs = append(recipientIDs, members[idx])
+			}
+			var receipt *chatsReceipt
+			if len(recipients) > 0 {
+				receipt = channel.addChatsReceipt(messageID, ur.id, recipientIDs)
+			}
+
+			// Loop through recipient connections, sending out payload. Each
+			// recipient is marked as delivered, once its connection has
+			// written the payload.
+			for _, idx := range recipients {
+				connection := connections[idx]
+				err = connection.RawSendWithWritten(payload, m.chatsReceiptWritten(channel, receipt, members[idx]))
 				if err != nil {
 					connection.Logger().WithError(err).WithField("channel", channel.id).Errorln("failed to send channel chats message to connection")
 				}
 			}
 
+			if targets != nil {
+				// Private messages are neither updateable nor recorded, since
+				// both would expose them to the whole channel.
+				return nil
+			}
+
+			// Remember message, so it can be updated later.
+			channel.addChatsMessage(extra, profile)
+
+			// Record message for history.
+			if m.chatsStore != nil {
+				storeErr := m.chatsStore.Add(m.ctx, channel.id, &chats.Record{
+					Message: extra,
+					Profile: profile,
+				})
+				if storeErr != nil {
+					m.logger.WithError(storeErr).WithField("channel", channel.id).Errorln("failed to store channel chats message")
+				}
+			}
+
 			return nil
 		}()
 		if err != nil {
@@ -236,6 +386,127 @@ func (m *Manager) processChatsMessage(c *connection.Connection, msg *api.RTMType
 			m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to send channel chats delivery system message to sender")
 		}
 
+	case api.RTMSubtypeNameChatsHistory:
+		channel, channelErr := m.getChatsChannel(c, msg, ur)
+		if channelErr != nil {
+			return channelErr
+		}
+
+		// Check extra data.
+		extra := &api.RTMDataChatsHistoryRequest{}
+		if msg.Data != nil {
+			err = json.Unmarshal(msg.Data, extra)
+			if err != nil {
+				return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "history data parse error", msg.ID)
+			}
+		}
+
+		history := &api.RTMDataChatsHistory{
+			Messages: make([]*api.RTMDataChatsMessage, 0),
+		}
+		if m.chatsStore != nil {
+			records, more, storeErr := m.chatsStore.History(m.ctx, channel.id, &chats.HistoryQuery{
+				Before:   extra.Before,
+				BeforeTS: extra.BeforeTS,
+				Limit:    extra.Limit,
+			})
+			switch storeErr {
+			case nil:
+			case chats.ErrMessageNotFound:
+				return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "history message not found", msg.ID)
+			default:
+				m.logger.WithError(storeErr).WithField("channel", channel.id).Errorln("failed to fetch channel chats history")
+				return api.NewRTMTypeError(api.RTMErrorIDServerError, "failed to fetch history", msg.ID)
+			}
+			history.More = more
+			history.Profiles = make(map[string]*api.RTMDataProfile)
+			for _, record := range records {
+				history.Messages = append(history.Messages, record.Message)
+				if record.Profile != nil {
+					history.Profiles[record.Message.Sender] = record.Profile
+				}
+			}
+		}
+
+		message, err := json.MarshalIndent(history, "", "\t")
+		if err != nil {
+			m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode channel chats history")
+			return nil
+		}
+		err = c.Send(&api.RTMTypeChatsReply{
+			RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
+				Type:    api.RTMTypeNameChats,
+				Subtype: api.RTMSubtypeNameChatsHistory,
+				ReplyTo: msg.ID,
+			},
+			Channel: channel.id,
+			Data:    message,
+			Version: currentChatsPayloadVersion,
+		})
+		if err != nil {
+			m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to send channel chats history")
+		}
+
+	case api.RTMSubtypeNameChatsEdit, api.RTMSubtypeNameChatsDelete, api.RTMSubtypeNameChatsReact:
+		channel, channelErr := m.getChatsChannel(c, msg, ur)
+		if channelErr != nil {
+			return channelErr
+		}
+		if msg.Data == nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "data is empty", msg.ID)
+		}
+
+		return m.processChatsMessageUpdate(c, msg, ur, channel)
+
+	case api.RTMSubtypeNameChatsRead:
+		channel, channelErr := m.getChatsChannel(c, msg, ur)
+		if channelErr != nil {
+			return channelErr
+		}
+		if msg.Data == nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "data is empty", msg.ID)
+		}
+
+		// Check extra data.
+		var extra *api.RTMDataChatsRead
+		err = json.Unmarshal(msg.Data, &extra)
+		if err != nil || extra == nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "read data parse error", msg.ID)
+		}
+		if len(extra.IDs) > maximalChatsReadIDs {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "read ids limit exceeded", msg.ID)
+		}
+
+		m.readChatsReceipts(channel, ur.id, extra.IDs)
+
+	case api.RTMSubtypeNameChatsTyping:
+		channel, channelErr := m.getChatsChannel(c, msg, ur)
+		if channelErr != nil {
+			return channelErr
+		}
+		if msg.Data == nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "data is empty", msg.ID)
+		}
+
+		// Check extra data.
+		var extra *api.RTMDataChatsTyping
+		err = json.Unmarshal(msg.Data, &extra)
+		if err != nil || extra == nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "typing data parse error", msg.ID)
+		}
+		// Typing sender must be empty.
+		if extra.Sender != "" {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "typing sender must be empty", msg.ID)
+		}
+
+		// Create profile.
+		profile := &api.RTMDataProfile{}
+		if ur.auth != nil {
+			profile.Name = ur.auth.Name()
+		}
+
+		m.updateChannelChatsTyping(channel, c, ur.id, profile, extra.Typing)
+
 	default:
 		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "unknown subtype", msg.ID)
 	}
@@ -243,6 +514,49 @@ func (m *Manager) processChatsMessage(c *connection.Connection, msg *api.RTMType
 	return nil
 }
 
+// emitChannelChatsSystem sends a system text message with the provided text
+// and extra data to all connections of the provided channel.
+func (m *Manager) emitChannelChatsSystem(channel *Channel, sender string, profile *api.RTMDataProfile, text string, extra map[string]interface{}) {
+	message, err := json.MarshalIndent(&api.RTMDataChatsMessage{
+		ID:     rndm.GenerateRandomString(12),
+		Kind:   api.RTMChatsMessageKindSystemText,
+		TS:     time.Now().Unix(),
+		Sender: sender,
+		Text:   text,
+		Extra:  extra,
+	}, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode channel chats system message")
+		return
+	}
+	payload, err := json.MarshalIndent(&api.RTMTypeChats{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameChats,
+			Subtype: api.RTMSubtypeNameChatsSystem,
+		},
+		Channel: channel.id,
+		Profile: profile,
+		Data:    message,
+		Version: currentChatsPayloadVersion,
+	}, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode channel chats system data")
+		return
+	}
+
+	channel.namedMutexLock(channelMutexChats)
+	defer channel.namedMutexUnlock(channelMutexChats)
+
+	// Loop through channel connections, sending out payload.
+	_, connections := channel.Connections()
+	for _, connection := range connections {
+		err = connection.RawSend(payload)
+		if err != nil {
+			connection.Logger().WithError(err).WithField("channel", channel.id).Errorln("failed to send channel chats system message to connection")
+		}
+	}
+}
+
 func (m *Manager) emitChannelChatsAddOrRemove(c *connection.Connection, channel *Channel, op ChannelOp, id string) error {
 	// Fech user record for connection.
 	bound := c.Bound()
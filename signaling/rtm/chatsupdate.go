@@ -0,0 +1,275 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/chats"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// maximalChannelChatsMessages is the number of most recent chats messages per
+// channel which are kept in memory, so they can be updated.
+const maximalChannelChatsMessages = 1000
+
+// maximalChatsReactionSize is the maximum number of bytes of a reaction.
+const maximalChatsReactionSize = 32
+
+// maximalChatsMessageReactions is the maximum number of different reactions
+// per chats message.
+const maximalChatsMessageReactions = 32
+
+// addChatsMessage remembers the provided message of the accociated channel.
+// The caller must hold the channel's chats mutex.
+func (c *Channel) addChatsMessage(message *api.RTMDataChatsMessage, profile *api.RTMDataProfile) {
+	if len(c.chatsOrder) >= maximalChannelChatsMessages {
+		delete(c.chats, c.chatsOrder[0])
+		c.chatsOrder = c.chatsOrder[1:]
+	}
+	c.chats[message.ID] = &chats.Record{
+		Message: message,
+		Profile: profile,
+	}
+	c.chatsOrder = append(c.chatsOrder, message.ID)
+}
+
+// getChatsRecord returns the record of the chats message with the provided id
+// in the provided channel, either from memory or from the chats store. The
+// caller must hold the channel's chats mutex.
+func (m *Manager) getChatsRecord(channel *Channel, id string) (*chats.Record, bool) {
+	if record, ok := channel.chats[id]; ok {
+		return record, true
+	}
+	if m.chatsStore == nil {
+		return nil, false
+	}
+
+	record, err := m.chatsStore.Get(m.ctx, channel.id, id)
+	if err != nil {
+		if err != chats.ErrMessageNotFound {
+			m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to fetch channel chats message from store")
+		}
+		return nil, false
+	}
+	if record.Message == nil {
+		return nil, false
+	}
+
+	return record, true
+}
+
+func (m *Manager) processChatsMessageUpdate(c *connection.Connection, msg *api.RTMTypeChats, ur *userRecord, channel *Channel) error {
+	var id string
+	var extra *api.RTMDataChatsMessage
+	var reaction *api.RTMDataChatsReaction
+
+	// Check extra data.
+	switch msg.Subtype {
+	case api.RTMSubtypeNameChatsReact:
+		err := json.Unmarshal(msg.Data, &reaction)
+		if err != nil || reaction == nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "reaction data parse error", msg.ID)
+		}
+		// Reaction sender must be empty.
+		if reaction.Sender != "" {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "reaction sender must be empty", msg.ID)
+		}
+		if !isValidChatsReaction(reaction.Reaction) {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "invalid reaction", msg.ID)
+		}
+		id = reaction.ID
+
+	default:
+		err := json.Unmarshal(msg.Data, &extra)
+		if err != nil || extra == nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message data parse error", msg.ID)
+		}
+		// Message sender must be empty.
+		if extra.Sender != "" {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message sender must be empty", msg.ID)
+		}
+		if msg.Subtype == api.RTMSubtypeNameChatsEdit {
+			if err = m.validateChatsUserText(extra, msg); err != nil {
+				return err
+			}
+		}
+		id = extra.ID
+	}
+	if id == "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message id is empty", msg.ID)
+	}
+
+	// Scope with lock, to ensure chat message order per channel.
+	channel.namedMutexLock(channelMutexChats)
+	defer channel.namedMutexUnlock(channelMutexChats)
+
+	record, ok := m.getChatsRecord(channel, id)
+	if !ok {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message not found", msg.ID)
+	}
+	message := record.Message
+	if message.Kind != api.RTMChatsMessageKindMessageUserText {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message cannot be changed", msg.ID)
+	}
+	if message.Deleted {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message was deleted", msg.ID)
+	}
+
+	var data interface{}
+	switch msg.Subtype {
+	case api.RTMSubtypeNameChatsEdit:
+		// Only sender or moderators can edit.
+		if message.Sender != ur.id && !m.isChannelModerator(channel, ur) {
+			return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "not allowed to edit message", msg.ID)
+		}
+		message.Text = extra.Text
+		message.RichText = extra.RichText
+		message.Edited = time.Now().Unix()
+		data = message
+
+	case api.RTMSubtypeNameChatsDelete:
+		// Only sender or moderators can delete.
+		if message.Sender != ur.id && !m.isChannelModerator(channel, ur) {
+			return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "not allowed to delete message", msg.ID)
+		}
+		message.Text = ""
+		message.RichText = ""
+		message.Reactions = nil
+		message.Deleted = true
+		message.Edited = time.Now().Unix()
+		data = message
+
+	case api.RTMSubtypeNameChatsReact:
+		if !updateChatsMessageReactions(message, reaction.Reaction, ur.id, reaction.Remove) {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "message reaction limit exceeded", msg.ID)
+		}
+		reaction.Sender = ur.id
+		data = reaction
+	}
+
+	// Update stored message.
+	if m.chatsStore != nil {
+		storeErr := m.chatsStore.Update(m.ctx, channel.id, record)
+		if storeErr != nil && storeErr != chats.ErrMessageNotFound {
+			m.logger.WithError(storeErr).WithField("channel", channel.id).Errorln("failed to update stored channel chats message")
+		}
+	}
+
+	// Encode payload (only once, same message for everyone).
+	encoded, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode channel chats update")
+		return nil
+	}
+	payload, err := json.MarshalIndent(&api.RTMTypeChats{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameChats,
+			Subtype: msg.Subtype,
+		},
+		Channel: channel.id,
+		Data:    encoded,
+		Version: currentChatsPayloadVersion,
+	}, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode channel chats update data")
+		return nil
+	}
+
+	// Reply to sender.
+	err = c.Send(&api.RTMTypeChatsReply{
+		RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
+			Type:    api.RTMTypeNameChats,
+			Subtype: msg.Subtype,
+			ReplyTo: msg.ID,
+		},
+		Channel: channel.id,
+		Data:    encoded,
+		Version: currentChatsPayloadVersion,
+	})
+	if err != nil {
+		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to send channel chats update reply to sender")
+		return err
+	}
+
+	// Loop through channel connections, sending out payload.
+	_, connections := channel.Connections()
+	for _, connection := range connections {
+		if connection == c {
+			// Skip sending message to sender (self).
+			continue
+		}
+		err = connection.RawSend(payload)
+		if err != nil {
+			connection.Logger().WithError(err).WithField("channel", channel.id).Errorln("failed to send channel chats update to connection")
+		}
+	}
+
+	return nil
+}
+
+// isValidChatsReaction returns true if the provided reaction is a short
+// single token, usually an emoji.
+func isValidChatsReaction(reaction string) bool {
+	if reaction == "" || len(reaction) > maximalChatsReactionSize || !utf8.ValidString(reaction) {
+		return false
+	}
+
+	return strings.IndexFunc(reaction, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}) == -1
+}
+
+// updateChatsMessageReactions adds or removes the reaction of the user with
+// the provided id to the provided message. It returns false, if the message
+// has too many different reactions already.
+func updateChatsMessageReactions(message *api.RTMDataChatsMessage, reaction string, id string, remove bool) bool {
+	users := message.Reactions[reaction]
+	for idx, user := range users {
+		if user == id {
+			if remove {
+				users = append(users[:idx:idx], users[idx+1:]...)
+				if len(users) == 0 {
+					delete(message.Reactions, reaction)
+				} else {
+					message.Reactions[reaction] = users
+				}
+			}
+			return true
+		}
+	}
+	if remove {
+		return true
+	}
+
+	if users == nil {
+		if len(message.Reactions) >= maximalChatsMessageReactions {
+			return false
+		}
+		if message.Reactions == nil {
+			message.Reactions = make(map[string][]string)
+		}
+	}
+	message.Reactions[reaction] = append(users, id)
+	return true
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// SetDrain enables drain mode with the provided timeout. Clients are asked to
+// reconnect to the provided URL, if any, after the provided retry duration.
+func (m *Manager) SetDrain(timeout time.Duration, url string, retryAfter time.Duration) error {
+	if timeout < 0 || retryAfter < 0 {
+		return errors.New("invalid drain duration")
+	}
+
+	m.drainTimeout = timeout
+	m.drainURL = url
+	m.drainRetryAfter = retryAfter
+	if timeout > 0 {
+		m.logger.WithFields(logrus.Fields{
+			"timeout":     timeout,
+			"url":         url,
+			"retry_after": retryAfter,
+		}).Infoln("drain mode enabled")
+	}
+
+	return nil
+}
+
+// DrainTimeout returns the maximum duration to wait for active group channels
+// when draining. 0 means drain mode is not enabled.
+func (m *Manager) DrainTimeout() time.Duration {
+	return m.drainTimeout
+}
+
+// Draining returns a channel which is closed when the accociated manager
+// starts draining.
+func (m *Manager) Draining() <-chan struct{} {
+	return m.drainCh
+}
+
+// IsDraining returns true if the accociated manager is draining.
+func (m *Manager) IsDraining() bool {
+	return atomic.LoadInt32(&m.draining) == 1
+}
+
+// Drain starts drain mode. No new connections are accepted and connections
+// which are not in any channel are sent a goodbye and closed. Connections in
+// channels stay until they leave their last channel, or the server exits.
+// Returns false if already draining.
+func (m *Manager) Drain() bool {
+	if !atomic.CompareAndSwapInt32(&m.draining, 0, 1) {
+		return false
+	}
+	close(m.drainCh)
+
+	m.logger.Infoln("drain start")
+
+	active := make(map[*connection.Connection]bool)
+	for entry := range m.channels.IterBuffered() {
+		_, connections := entry.Val.(*channelRecord).channel.Connections()
+		for _, c := range connections {
+			active[c] = true
+		}
+	}
+
+	for entry := range m.connections.IterBuffered() {
+		c := entry.Val.(*connection.Connection)
+		if !active[c] {
+			m.drainConnection(c)
+		}
+	}
+
+	return true
+}
+
+// drainConnectionIfIdle sends the drain goodbye to the provided connection and
+// closes it, if the accociated manager is draining and the connection is not
+// in any channel.
+func (m *Manager) drainConnectionIfIdle(c *connection.Connection) {
+	if !m.IsDraining() || c.IsClosed() {
+		return
+	}
+
+	for entry := range m.channels.IterBuffered() {
+		_, connections := entry.Val.(*channelRecord).channel.Connections()
+		for _, cc := range connections {
+			if cc == c {
+				return
+			}
+		}
+	}
+
+	m.drainConnection(c)
+}
+
+// drainConnection sends the drain goodbye to the provided connection and
+// closes it.
+func (m *Manager) drainConnection(c *connection.Connection) {
+	err := c.Send(&api.RTMTypeHello{
+		Type:   api.RTMTypeNameGoodbye,
+		Reason: api.RTMGoodbyeReasonDraining,
+
+		URL:        m.drainURL,
+		RetryAfter: int64(m.drainRetryAfter / time.Second),
+	})
+	if err != nil {
+		c.Logger().WithError(err).Debugln("failed to send drain goodbye")
+	}
+	c.Close()
+}
+
+// NumActiveGroupChannels returns the number of group channels which have
+// members.
+func (m *Manager) NumActiveGroupChannels() int {
+	count := 0
+	for entry := range m.channels.IterBuffered() {
+		channel := entry.Val.(*channelRecord).channel
+		if channel.config.Group != "" && channel.Size() > 0 {
+			count++
+		}
+	}
+
+	return count
+}
+
+// refuseWhenDraining replies with an error and returns true if the accociated
+// manager is draining.
+func (m *Manager) refuseWhenDraining(rw http.ResponseWriter) bool {
+	if !m.IsDraining() {
+		return false
+	}
+
+	if m.drainRetryAfter > 0 {
+		rw.Header().Set("Retry-After", strconv.FormatInt(int64(m.drainRetryAfter/time.Second), 10))
+	}
+	http.Error(rw, "server is draining", http.StatusServiceUnavailable)
+	return true
+}
+
+func (m *Manager) drainHandler(rw http.ResponseWriter, req *http.Request) {
+	if m.drainTimeout <= 0 {
+		http.Error(rw, "drain mode is not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	if !m.Drain() {
+		http.Error(rw, "already draining", http.StatusConflict)
+		return
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+)
+
+func TestWebsocketRefusedWhenDraining(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := newTestManager(ctx)
+	if err := m.SetDrain(time.Minute, "", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	m.keys.Set("key1", &keyRecord{user: &userRecord{id: "user1"}})
+
+	router := mux.NewRouter()
+	router.Handle("/websocket/{key}", http.HandlerFunc(m.HTTPWebsocketHandler))
+
+	tests := []struct {
+		draining   bool
+		status     int
+		retryAfter string
+	}{
+		{false, http.StatusForbidden, ""},
+		{true, http.StatusServiceUnavailable, "5"},
+	}
+
+	for idx, test := range tests {
+		if test.draining {
+			m.keys.Set("key1", &keyRecord{user: &userRecord{id: "user1"}})
+			m.Drain()
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/websocket/key1", nil))
+
+		if rr.Code != test.status {
+			t.Errorf("%d: status %v does not match expected %v", idx, rr.Code, test.status)
+		}
+		if retryAfter := rr.Header().Get("Retry-After"); retryAfter != test.retryAfter {
+			t.Errorf("%d: Retry-After %q does not match expected %q", idx, retryAfter, test.retryAfter)
+		}
+	}
+}
+
+func TestDrainConnectionsInChannels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := newTestManager(ctx)
+	if err := m.SetDrain(time.Minute, "", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	channel := CreateKnownChannel("@group1", m, &ChannelConfig{
+		Group: "group1",
+	})
+	m.channels.Set(channel.id, &channelRecord{channel: channel})
+
+	member := addTestChannelMember(t, channel, "user1", &api.AdminAuthToken{})
+	idle := newTestConnection(t, "user2")
+	m.connections.Set(member.ID(), member)
+	m.connections.Set(idle.ID(), idle)
+
+	m.Drain()
+	if !idle.IsClosed() {
+		t.Errorf("expected connection without channel to be closed")
+	}
+	if member.IsClosed() {
+		t.Errorf("expected connection in channel to stay")
+	}
+
+	if err := channel.Remove("user1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && !member.IsClosed(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !member.IsClosed() {
+		t.Errorf("expected connection to be closed after leaving its last channel")
+	}
+}
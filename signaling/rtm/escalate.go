@@ -0,0 +1,297 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+	"stash.kopano.io/kgol/rndm"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// processWebRTCEscalate handles escalations of established 1:1 calls into
+// group calls and invitations of further users into group channels.
+func (m *Manager) processWebRTCEscalate(c *connection.Connection, msg *api.RTMTypeWebRTC, ur *userRecord, auth *api.AdminAuthToken) error {
+	// Connection must have a user.
+	if ur == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection has no user", msg.ID)
+	}
+	// State must always be not empty.
+	if msg.State == "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "state is empty", msg.ID)
+	}
+	// Source must always be empty when received here.
+	if msg.Source != "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "source must be empty", msg.ID)
+	}
+	if msg.Channel == "" || msg.Hash == "" || msg.Data == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel hash or data is empty", msg.ID)
+	}
+
+	var escalate *api.RTMDataWebRTCEscalate
+	if err := json.Unmarshal(msg.Data, &escalate); err != nil || escalate == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "escalate data parse error", msg.ID)
+	}
+	if len(escalate.Invite) > maxCallTargets {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "too many invites", msg.ID)
+	}
+
+	record, ok := m.channels.Get(msg.Channel)
+	if !ok {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel not found", msg.ID)
+	}
+	channel := record.(*channelRecord).channel
+	if err := channel.checkWebRTCMessage(ur.id, msg); err != nil {
+		return err
+	}
+
+	// Sending connection must be in channel.
+	if cc, _ := channel.Get(ur.id); cc != c {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection not in channel", msg.ID)
+	}
+
+	if msg.Group != "" {
+		// Invite further users into group, moderators only.
+		if !m.isChannelModerator(channel, ur) {
+			return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "not a moderator", msg.ID)
+		}
+		m.sendGroupInvites(ur, msg.Group, escalate.Invite)
+
+		return c.Send(&api.RTMTypeWebRTCReply{
+			RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
+				Type:    api.RTMTypeNameWebRTC,
+				Subtype: api.RTMSubtypeNameWebRTCEscalate,
+				ReplyTo: msg.ID,
+			},
+			Channel: channel.id,
+			Version: currentWebRTCPayloadVersion,
+		})
+	}
+
+	return m.escalateCall(c, msg, ur, auth, channel, escalate)
+}
+
+// escalateCall moves the sender and its peer from the provided 1:1 call
+// channel into a new channel of the group given in the escalate data, then
+// invites the users given in the escalate data into the group.
+func (m *Manager) escalateCall(c *connection.Connection, msg *api.RTMTypeWebRTC, ur *userRecord, auth *api.AdminAuthToken, channel *Channel, escalate *api.RTMDataWebRTCEscalate) error {
+	if !channel.isCall() {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel cannot be escalated", msg.ID)
+	}
+	if auth != nil && (auth.IsGuest() || !auth.CanCreateChannels) {
+		return api.NewRTMTypeError(api.RTMErrorIDCreateRestricted, "access denied", msg.ID)
+	}
+	peer := msg.Target
+	peerConn, ok := channel.Get(peer)
+	if !ok || peerConn == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDNoSessionForUser, "target not found", msg.ID)
+	}
+	peerUr, _ := peerConn.Bound().(*userRecord)
+	if peerUr == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDNoSessionForUser, "target not found", msg.ID)
+	}
+
+	group := escalate.Group
+	if group == "" {
+		group = rndm.GenerateRandomString(channelIDSize)
+	}
+	groupChannelID, err := CreateNamedGroupChannelID(group, m)
+	if err != nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
+	}
+
+	// Apply the group rules for the sender and the peer, as when joining the
+	// group. The passcode is given by the sender, who brings the peer along.
+	groupMsg := *msg
+	groupMsg.Group = group
+	meeting, err := m.getGroupMeeting(&groupMsg)
+	if err != nil {
+		return err
+	}
+	isModerator := auth != nil && auth.IsModerator(group)
+	isPeerModerator := peerUr.auth != nil && !peerUr.auth.IsGuest() && peerUr.auth.IsModerator(group)
+	maxParticipants := m.getGroupMaxParticipants(group, auth)
+	if meeting != nil {
+		if !meeting.Allows(ur.id, auth) && !isModerator {
+			return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "not invited to meeting", msg.ID)
+		}
+		if !meeting.Allows(peer, peerUr.auth) && !isPeerModerator {
+			return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "target not invited to meeting", msg.ID)
+		}
+		if meeting.MaxParticipants > 0 {
+			maxParticipants = meeting.MaxParticipants
+		}
+	}
+	if !isModerator {
+		if err = m.checkGroupPasscode(&groupMsg, ur); err != nil {
+			return err
+		}
+	}
+	// Group must have room for both.
+	if maxParticipants > 0 && maxParticipants < 2 {
+		return api.NewRTMTypeError(api.RTMErrorIDChannelFull, ErrChannelFull.Error(), msg.ID)
+	}
+
+	// Get or create group channel, which must not be in use.
+	groupChannel, err := m.upsertGroupChannel(groupChannelID, group, maxParticipants)
+	if err != nil {
+		return err
+	}
+	if groupChannel.Size() > 0 {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "group already in use", msg.ID)
+	}
+	if groupChannel.isBlocked(ur.id) || groupChannel.isBlocked(peer) {
+		return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "removed from channel by moderator", msg.ID)
+	}
+
+	// Move both into group channel first, so the call stays when that fails.
+	// The sender becomes moderator. Senders are never guests and the channel
+	// is empty, so the sender never waits in the lobby.
+	if err = groupChannel.Add(ur.id, c); err != nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
+	}
+	groupChannel.setPayloadVersion(ur.id, msg.Version)
+	groupChannel.setModeratorIfNone(ur.id)
+	// Hold back the peer in the lobby, as when joining the group.
+	lobby := m.needsGroupLobby(groupChannel, peerUr, peerUr.auth)
+	if !lobby {
+		if err = groupChannel.Add(peer, peerConn); err != nil {
+			groupChannel.Remove(ur.id)
+			if groupChannel.Cleanup() {
+				m.channels.Remove(groupChannel.id)
+			}
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
+		}
+	}
+
+	// Leave and remove call channel.
+	channel.stopCallRing()
+	channel.Remove(ur.id)
+	channel.Remove(peer)
+	if channel.Cleanup() {
+		m.channels.Remove(channel.id)
+	}
+
+	// Let both know about the group channel.
+	data := m.getGroupChannelExtra(groupChannel, msg.Version)
+	data.Replaces = channel.id
+	extra, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+	c.Send(&api.RTMTypeWebRTCReply{
+		RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCEscalate,
+			ReplyTo: msg.ID,
+		},
+		Channel: groupChannel.id,
+		Hash:    base64.StdEncoding.EncodeToString(computeWebRTCChannelHash(msg.Type, ur.id, group, groupChannel.id)),
+		Version: currentWebRTCPayloadVersion,
+		Data:    extra,
+	})
+
+	// The payload version of the peer is unknown, so no profiles.
+	data = m.getGroupChannelExtra(groupChannel, 0)
+	data.Replaces = channel.id
+	extra, err = json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+	peerMsg := &api.RTMTypeWebRTC{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCEscalate,
+		},
+		Target:  peer,
+		Source:  ur.id,
+		Profile: m.getUserProfile(ur),
+		State:   msg.State,
+		Channel: groupChannel.id,
+		Group:   group,
+		Version: currentWebRTCPayloadVersion,
+		Data:    extra,
+	}
+	if !lobby {
+		peerMsg.Hash = base64.StdEncoding.EncodeToString(computeWebRTCChannelHash(msg.Type, peer, group, groupChannel.id))
+	}
+	peerConn.Send(peerMsg)
+	if lobby {
+		// The peer joins with the group message when admitted.
+		err = m.enterGroupLobby(peerConn, &api.RTMTypeWebRTC{
+			RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+				Type:    api.RTMTypeNameWebRTC,
+				Subtype: api.RTMSubtypeNameWebRTCGroup,
+			},
+			Target: group,
+			Group:  group,
+			State:  msg.State,
+		}, peerUr, groupChannel)
+		if err != nil {
+			peerConn.Logger().WithError(err).WithField("channel", groupChannel.id).Debugln("failed to enter group lobby after escalation")
+		}
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"channel": channel.id,
+		"group":   group,
+		"peer":    peer,
+		"lobby":   lobby,
+		"invite":  len(escalate.Invite),
+	}).Debugln("webrtc call escalated to group")
+
+	m.sendGroupInvites(ur, group, escalate.Invite)
+	return nil
+}
+
+// sendGroupInvites invites the provided users into the provided group on
+// behalf of the provided user. Invited users join the group as usual.
+func (m *Manager) sendGroupInvites(ur *userRecord, group string, invite []string) {
+	if len(invite) == 0 {
+		return
+	}
+
+	profile := m.getUserProfile(ur)
+	for _, target := range invite {
+		if target == ur.id {
+			continue
+		}
+		connections, ok := m.LookupConnectionsByID(target)
+		if !ok {
+			continue
+		}
+		for _, connection := range connections {
+			connection.Send(&api.RTMTypeWebRTC{
+				RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+					Type:    api.RTMTypeNameWebRTC,
+					Subtype: api.RTMSubtypeNameWebRTCEscalate,
+				},
+				Target:    target,
+				Source:    ur.id,
+				Profile:   profile,
+				Initiator: true,
+				Group:     group,
+				Version:   currentWebRTCPayloadVersion,
+			})
+		}
+	}
+}
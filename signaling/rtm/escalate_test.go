@@ -0,0 +1,124 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+)
+
+func TestEscalateCall(t *testing.T) {
+	tests := []struct {
+		name     string
+		group    string
+		passcode string
+		given    string
+		code     string
+		joined   bool
+		waiting  bool
+	}{
+		{"join", "group1", "", "", "", true, false},
+		{"lobby", "lobby1", "", "", "", false, true},
+		{"passcode of sender", "group1", "secret", "secret", "", true, false},
+		{"wrong passcode", "group1", "secret", "wrong", api.RTMErrorIDPasscodeInvalid, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			m := newTestManager(ctx)
+			if err := m.SetGroupLobby("^lobby", false); err != nil {
+				t.Fatal(err)
+			}
+			var gp *groupPasscode
+			if test.passcode != "" {
+				gp = newGroupPasscode(test.passcode, false)
+				m.groupPasscodes.Set(test.group, gp)
+			}
+
+			channel := CreateRandomChannel(m, nil)
+			m.channels.Set(channel.id, &channelRecord{channel: channel})
+			auth := &api.AdminAuthToken{CanCreateChannels: true}
+			c := addTestChannelMember(t, channel, "user1", auth)
+			addTestChannelMember(t, channel, "user2", &api.AdminAuthToken{})
+
+			escalate := &api.RTMDataWebRTCEscalate{
+				Group:    test.group,
+				Passcode: test.given,
+			}
+			data, err := json.Marshal(escalate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.escalateCall(c, &api.RTMTypeWebRTC{
+				RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+					ID:      1,
+					Type:    api.RTMTypeNameWebRTC,
+					Subtype: api.RTMSubtypeNameWebRTCEscalate,
+				},
+				Target:  "user2",
+				State:   "state1",
+				Channel: channel.id,
+				Data:    data,
+			}, c.Bound().(*userRecord), auth, channel, escalate)
+
+			if test.code != "" {
+				if rtmErr, ok := err.(*api.RTMTypeError); !ok || rtmErr.ErrorData.Code != test.code {
+					t.Fatalf("expected error %v, got %v", test.code, err)
+				}
+				if channel.Size() != 2 {
+					t.Errorf("expected call to stay")
+				}
+				if _, ok := gp.failures["user2"]; ok {
+					t.Errorf("expected wrong passcode not to be counted for the peer")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			channelID, _ := CreateNamedGroupChannelID(test.group, m)
+			record, ok := m.channels.Get(channelID)
+			if !ok {
+				t.Fatal("group channel not found")
+			}
+			groupChannel := record.(*channelRecord).channel
+			if cc, _ := groupChannel.Get("user1"); cc != c {
+				t.Errorf("expected sender in group channel")
+			}
+			if cc, _ := groupChannel.Get("user2"); (cc != nil) != test.joined {
+				t.Errorf("expected peer joined %v", test.joined)
+			}
+			groupChannel.RLock()
+			_, waiting := groupChannel.lobby["user2"]
+			groupChannel.RUnlock()
+			if waiting != test.waiting {
+				t.Errorf("expected peer waiting %v, got %v", test.waiting, waiting)
+			}
+			if _, ok := m.channels.Get(channel.id); ok {
+				t.Errorf("expected call channel to be removed")
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ package rtm
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -32,12 +33,20 @@ import (
 func (m *Manager) OnConnect(c *connection.Connection) error {
 	c.Logger().Debugln("websocket rtm connect")
 
+	// NOTE(longsleep): The session is attached only after the connection was
+	// accepted, so a rejected connection never takes over a session.
+	request := m.popSessionRequest(c)
+
 	var self *api.Self
+	online := false
 	bound := c.Bound()
 	if bound != nil {
 		// Add user to table.
 		nur := bound.(*userRecord)
 		first := false
+		rejected := false
+		var evicted []*connection.Connection
+		resuming := m.resumingConnection(request)
 		nur.Lock()
 		entry := m.users.Upsert(nur.id, c, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
 			if !exist {
@@ -45,23 +54,51 @@ func (m *Manager) OnConnect(c *connection.Connection) error {
 				nur.connections = append(nur.connections, newValue.(*connection.Connection))
 				nur.when = time.Now()
 				first = true
+				online = true
 				return nur
 			}
 
 			connection := newValue.(*connection.Connection)
 			ur := valueInMap.(*userRecord)
 			ur.Lock()
-			ur.connections = append(ur.connections, connection)
-			// TODO(longsleep): Limit maximum number of connections.
+			evicted, rejected = m.applyConnectionLimit(ur.connections, resuming)
+			if !rejected {
+				if len(ur.connections) == 0 {
+					online = true
+				}
+				ur.connections = append(ur.connections, connection)
+			}
 			ur.Unlock()
 
-			// Overwrite the connections user record.
-			connection.Bind(ur)
+			if rejected {
+				// Unbind, the connection never was added to the user record.
+				connection.Bind(nil)
+			} else {
+				// Overwrite the connections user record.
+				connection.Bind(ur)
+			}
 
 			return ur
 		})
 		nur.Unlock()
 
+		if rejected {
+			connectionRejected.WithLabelValues(m.id).Inc()
+			c.Logger().WithField("max", m.connectionsPerUserMax).Debugln("websocket rtm connect rejected, connection limit reached")
+			err := c.Send(api.NewRTMTypeError(api.RTMErrorIDConnectionLimit, "too many connections", 0))
+			c.Close()
+			return err
+		}
+		for _, connection := range evicted {
+			connectionEvicted.WithLabelValues(m.id, m.connectionsPerUserPolicy).Inc()
+			connection.Logger().WithField("policy", m.connectionsPerUserPolicy).Debugln("websocket rtm evicted, connection limit reached")
+			connection.Send(&api.RTMTypeHello{
+				Type:   api.RTMTypeNameGoodbye,
+				Reason: api.RTMGoodbyeReasonConnectionLimit,
+			})
+			connection.Close()
+		}
+
 		// Fill self with user record.
 		ur := entry.(*userRecord)
 		self = &api.Self{
@@ -77,12 +114,23 @@ func (m *Manager) OnConnect(c *connection.Connection) error {
 		}
 	}
 
+	if request != nil {
+		m.attachSession(c, request.userID, request.resume, request.resumeSeq)
+	}
+
 	// Send hello.
+	resume := m.resumeData(c)
+	userID := ""
+	if self != nil {
+		userID = self.ID
+	}
 	msg := &api.RTMTypeHello{
 		Type: api.RTMTypeNameHello,
 		Self: self,
 
-		ServerStatus: m.getServerStatus(),
+		Resume: resume,
+
+		ServerStatus: m.getServerStatusForUser(userID),
 	}
 	err := c.Send(msg)
 	if err != nil {
@@ -90,15 +138,62 @@ func (m *Manager) OnConnect(c *connection.Connection) error {
 	} else {
 		c.Logger().Debugln("websocket rtm connect done")
 	}
+
+	if resume != nil && resume.Resumed {
+		// Take over channel memberships of the resumed session.
+		m.resumeSession(c)
+		c.Logger().Debugln("websocket rtm session resumed")
+	}
+	if online {
+		m.emitPresence(self.ID, c)
+	}
 	return err
 }
 
+// applyConnectionLimit checks the provided connections of a user against the
+// per user connection limit. It returns the connections which are to be
+// evicted, or true if a new connection is to be rejected. Connections which
+// were or are about to be taken over by a resumed session do not count.
+func (m *Manager) applyConnectionLimit(connections []*connection.Connection, resuming *connection.Connection) ([]*connection.Connection, bool) {
+	if m.connectionsPerUserMax <= 0 {
+		return nil, false
+	}
+
+	active := make([]*connection.Connection, 0, len(connections))
+	for _, connection := range connections {
+		if connection != resuming && !connection.IsClosed() && !m.isSupersededConnection(connection) {
+			active = append(active, connection)
+		}
+	}
+	count := len(active) - m.connectionsPerUserMax + 1
+	if count <= 0 {
+		return nil, false
+	}
+
+	switch m.connectionsPerUserPolicy {
+	case ConnectionLimitPolicyEvictOldest:
+		// Connections are in order of their creation.
+	case ConnectionLimitPolicyEvictIdle:
+		sort.SliceStable(active, func(i, j int) bool {
+			return active[i].LastActive().Before(active[j].LastActive())
+		})
+	default:
+		return nil, true
+	}
+
+	return active[:count], false
+}
+
 // OnDisconnect is called after a connection has closed.
 func (m *Manager) OnDisconnect(c *connection.Connection) error {
 	c.Logger().Debugln("websocket rtm disconnect")
 
+	m.unsubscribePresence(c, nil)
+	m.rateLimiters.Remove(c.ID())
+
 	bound := c.Bound()
 	if bound != nil {
+		offline := false
 		ur := bound.(*userRecord)
 		m.users.Upsert(ur.id, c, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
 			if !exist {
@@ -124,14 +219,20 @@ func (m *Manager) OnDisconnect(c *connection.Connection) error {
 			}
 			if len(connections) == 0 {
 				ur.exit = time.Now()
+				offline = true
 			}
 			ur.connections = connections
 			ur.Unlock()
 
 			return ur
 		})
+		if offline {
+			m.emitPresence(ur.id, c)
+		}
 	}
 
+	m.detachSession(c)
+
 	c.Logger().Debugln("websocket rtm disconnect done")
 	return nil
 }
@@ -176,7 +277,11 @@ func (m *Manager) OnText(c *connection.Connection, msg []byte) error {
 }
 
 func (m *Manager) processTextMessage(c *connection.Connection, transaction *api.RTMTypeTransaction, msg []byte) error {
-	var err error
+	allowed, err := m.checkRateLimit(c, transaction.Type, msg)
+	if !allowed {
+		return err
+	}
+
 	switch transaction.Type {
 	case api.RTMTypeNamePing:
 		// Ping, Pong.
@@ -234,6 +339,15 @@ func (m *Manager) processTextMessage(c *connection.Connection, transaction *api.
 		}
 		err = m.onChats(c, &chats)
 
+	case api.RTMTypeNamePresence:
+		// Presence.
+		var presence api.RTMTypePresence
+		err = json.Unmarshal(msg, &presence)
+		if err != nil {
+			break
+		}
+		err = m.onPresence(c, &presence)
+
 	default:
 		return fmt.Errorf("unknown incoming type %v", transaction.Type)
 	}
@@ -268,26 +382,6 @@ func (m *Manager) OnServerStatus(newServerStatus *api.ServerStatus) error {
 
 	m.setServerStatus(newServerStatus)
 
-	// Prepare server hello message.
-	msg := &api.RTMTypeHello{
-		Type: api.RTMTypeNameServer,
-
-		ServerStatus: newServerStatus,
-	}
-	payload, err := json.MarshalIndent(msg, "", "\t")
-	if err != nil {
-		m.logger.WithError(err).Debugln("websocket server status hello send error")
-		return err
-	}
-
 	// Send updated server status to all connections.
-	for entry := range m.connections.IterBuffered() {
-		c := entry.Val.(*connection.Connection)
-		err = c.RawSend(payload)
-		if err != nil {
-			c.Logger().WithError(err).Errorln("failed to send server status to connection")
-		}
-	}
-
-	return nil
+	return m.sendServerStatus(nil)
 }
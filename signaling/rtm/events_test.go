@@ -0,0 +1,91 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"context"
+	"testing"
+
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+func TestApplyConnectionLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        int
+		policy     string
+		count      int
+		superseded bool
+		resuming   bool
+		evicted    []int
+		rejected   bool
+	}{
+		{"unlimited", 0, ConnectionLimitPolicyReject, 5, false, false, nil, false},
+		{"below limit", 3, ConnectionLimitPolicyReject, 2, false, false, nil, false},
+		{"reject at limit", 2, ConnectionLimitPolicyReject, 2, false, false, nil, true},
+		{"reject at limit with superseded", 2, ConnectionLimitPolicyReject, 2, true, false, nil, false},
+		{"reject at limit with resuming", 2, ConnectionLimitPolicyReject, 2, false, true, nil, false},
+		{"evict oldest", 2, ConnectionLimitPolicyEvictOldest, 3, false, false, []int{0, 1}, false},
+		{"evict oldest with superseded", 2, ConnectionLimitPolicyEvictOldest, 3, true, false, []int{1}, false},
+		{"evict oldest with resuming", 2, ConnectionLimitPolicyEvictOldest, 3, false, true, []int{1}, false},
+		{"evict idle", 1, ConnectionLimitPolicyEvictIdle, 1, false, false, []int{0}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			m := newTestManager(ctx)
+			if err := m.SetConnectionLimit(test.max, test.policy); err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []string{"c0", "c1", "c2", "c3", "c4"}
+			connections := make([]*connection.Connection, test.count)
+			for idx := range connections {
+				connections[idx] = newTestConnection(t, ids[idx])
+			}
+			if test.superseded {
+				// First connection was taken over by a resumed session.
+				m.connectionSessions.Set(connections[0].ID(), &sessionRecord{
+					superseded: connections[0],
+				})
+			}
+
+			var resuming *connection.Connection
+			if test.resuming {
+				// First connection is about to be taken over by a resumed session.
+				resuming = connections[0]
+			}
+
+			evicted, rejected := m.applyConnectionLimit(connections, resuming)
+			if rejected != test.rejected {
+				t.Errorf("expected rejected %v, got %v", test.rejected, rejected)
+			}
+			if len(evicted) != len(test.evicted) {
+				t.Fatalf("expected %d evicted, got %d", len(test.evicted), len(evicted))
+			}
+			for idx, expected := range test.evicted {
+				if evicted[idx] != connections[expected] {
+					t.Errorf("expected connection %d to be evicted, got %s", expected, evicted[idx].ID())
+				}
+			}
+		})
+	}
+}
@@ -18,8 +18,13 @@
 package rtm
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
 	"stash.kopano.io/kwm/kwmserver/signaling/connection"
@@ -31,45 +36,173 @@ func CreateNamedGroupChannelID(id string, m *Manager) (string, error) {
 	return fmt.Sprintf("%s%s", ChannelPrefixNamedGroup, id), nil
 }
 
-func (m *Manager) onGroupReplace(channel *Channel, id string, oldConn *connection.Connection, newConn *connection.Connection) {
-	data := &api.RTMDataWebRTCChannelExtra{}
-	data.Replaced = true
+// groupLimitRule is a maximum number of participants for groups with IDs
+// matching its pattern.
+type groupLimitRule struct {
+	pattern *regexp.Regexp
+	max     int
+}
+
+// parseGroupLimitRule parses the provided group limit rule string value. The
+// format is `REGEXP=MAX`.
+func parseGroupLimitRule(value string) (*groupLimitRule, error) {
+	idx := strings.LastIndex(value, "=")
+	if idx < 0 {
+		return nil, fmt.Errorf("invalid group limit rule: %v", value)
+	}
+	pattern, err := regexp.Compile(value[:idx])
+	if err != nil {
+		return nil, fmt.Errorf("invalid group limit rule pattern: %v", err)
+	}
+	max, err := strconv.Atoi(value[idx+1:])
+	if err != nil || max < 0 {
+		return nil, fmt.Errorf("invalid group limit rule max: %v", value)
+	}
+
+	return &groupLimitRule{
+		pattern: pattern,
+		max:     max,
+	}, nil
+}
+
+// getGroupMaxParticipants returns the maximum number of participants for a
+// new channel of the provided group, created with the provided auth. A limit
+// from the auth claims takes precedence over the first matching rule, which
+// takes precedence over the global limit. 0 means unlimited.
+func (m *Manager) getGroupMaxParticipants(group string, auth *api.AdminAuthToken) int {
+	if auth != nil && auth.MaxParticipants > 0 {
+		return auth.MaxParticipants
+	}
+	for _, rule := range m.groupLimitRules {
+		if rule.pattern.MatchString(group) {
+			return rule.max
+		}
+	}
+
+	return m.groupMaxParticipants
+}
+
+// upsertGroupChannel returns the channel with the provided ID of the provided
+// group, creating it with the provided maximum number of participants if it
+// does not exist.
+func (m *Manager) upsertGroupChannel(channelID string, group string, maxParticipants int) (*Channel, error) {
+	record := m.channels.Upsert(channelID, nil, func(exists bool, valueInMap interface{}, newValue interface{}) interface{} {
+		if exists && valueInMap != nil {
+			return valueInMap
+		}
+		newChannel := CreateKnownChannel(channelID, m, &ChannelConfig{
+			Group:           group,
+			MaxParticipants: maxParticipants,
+
+			Replace:          m.onGroupReplace,
+			AfterAddOrRemove: m.onAfterGroupAddOrRemove,
+			AfterReset:       m.onAfterGroupChannelReset,
+		})
+		return &channelRecord{
+			when:    time.Now(),
+			channel: newChannel,
+		}
+	})
+	if record == nil {
+		// We are fucked.
+		err := fmt.Errorf("channel upsert without result")
+		m.logger.WithError(err).WithField("channel", channelID).Errorln("failed to create channel for group")
+		return nil, err
+	}
+
+	return record.(*channelRecord).channel, nil
+}
+
+// joinGroupChannel adds the provided connection to the provided group channel
+// and replies to the provided group message with the channel data.
+func (m *Manager) joinGroupChannel(c *connection.Connection, msg *api.RTMTypeWebRTC, ur *userRecord, auth *api.AdminAuthToken, channel *Channel) error {
+	// Add user connection.
+	err := channel.Add(ur.id, c)
+	if err != nil {
+		if err == ErrChannelFull {
+			return api.NewRTMTypeError(api.RTMErrorIDChannelFull, err.Error(), msg.ID)
+		}
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
+	}
+	channel.setPayloadVersion(ur.id, msg.Version)
+	// The first non guest user becomes moderator.
+	if auth == nil || !auth.IsGuest() {
+		channel.setModeratorIfNone(ur.id)
+	}
+
+	// Create hash for channel.
+	//m.logger.Debugln("webrtc_group hash", channel.id, ur.id, msg.Group)
+	hash := computeWebRTCChannelHash(msg.Type, ur.id, msg.Group, channel.id)
+
+	// Add source, channel and hash.
+	msg.Source = ur.id
+	msg.Channel = channel.id
+	msg.Hash = base64.StdEncoding.EncodeToString(hash)
+
+	data := m.getGroupChannelExtra(channel, msg.Version)
 	extra, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
-		oldConn.Logger().WithField("channel", channel.id).Errorln("failed to encode group replace data")
-		return
+		return fmt.Errorf("failed to encode group data: %v", err)
 	}
 
-	err = oldConn.Send(&api.RTMTypeWebRTCReply{
+	// Send to self to populate channel and hash.
+	c.Send(&api.RTMTypeWebRTCReply{
 		RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
 			Type:    api.RTMTypeNameWebRTC,
 			Subtype: api.RTMSubtypeNameWebRTCChannel,
+			ReplyTo: msg.ID,
 		},
-		Channel: channel.id,
+		Channel: msg.Channel,
+		Hash:    msg.Hash,
 		Data:    extra,
 		Version: currentWebRTCPayloadVersion,
 	})
-	if err != nil {
-		oldConn.Logger().WithError(err).WithField("channel", channel.id).Errorln("failed to send group replace to connection")
-	}
+
+	// Send notices of the group, which were added before joining.
+	m.sendGroupServerNotices(c, ur.id, channel.config.Group)
+
+	return nil
 }
 
-func (m *Manager) onAfterGroupAddOrRemove(channel *Channel, op ChannelOp, id string) {
+// getGroupChannelExtra returns the full channel data of the provided group
+// channel as sent to new members with the provided payload version.
+func (m *Manager) getGroupChannelExtra(channel *Channel, version uint64) *api.RTMDataWebRTCChannelExtra {
+	// Get IDs of members in channel.
 	members, connections := channel.Connections()
 
 	data := &api.RTMDataWebRTCChannelExtra{}
 	data.Group = &api.RTMTDataWebRTCChannelGroup{
-		Group:   channel.config.Group,
-		Members: members,
-		Reset:   op == ChannelOpReset,
+		Group:      channel.config.Group,
+		Members:    members,
+		Reset:      true,
+		Moderators: m.getChannelModerators(channel),
+	}
+	if version >= profilesWebRTCPayloadVersion {
+		data.Group.Profiles = m.getChannelProfiles(members, connections)
+	}
+	if states := channel.getStates(); len(states) > 0 {
+		data.Group.States = states
 	}
+	if pipeline := channel.Pipeline(); pipeline != nil {
+		data.Pipeline = &api.RTMDataWebRTCChannelPipeline{
+			Pipeline: pipeline.ID(),
+			Mode:     pipeline.Mode(),
+		}
+	}
+
+	return data
+}
+
+func (m *Manager) onGroupReplace(channel *Channel, id string, oldConn *connection.Connection, newConn *connection.Connection) {
+	data := &api.RTMDataWebRTCChannelExtra{}
+	data.Replaced = true
 	extra, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
-		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode group data")
+		oldConn.Logger().WithField("channel", channel.id).Errorln("failed to encode group replace data")
 		return
 	}
 
-	payload, err := json.MarshalIndent(&api.RTMTypeWebRTCReply{
+	err = oldConn.Send(&api.RTMTypeWebRTCReply{
 		RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
 			Type:    api.RTMTypeNameWebRTC,
 			Subtype: api.RTMSubtypeNameWebRTCChannel,
@@ -77,11 +210,29 @@ func (m *Manager) onAfterGroupAddOrRemove(channel *Channel, op ChannelOp, id str
 		Channel: channel.id,
 		Data:    extra,
 		Version: currentWebRTCPayloadVersion,
-	}, "", "\t")
+	})
+	if err != nil {
+		oldConn.Logger().WithError(err).WithField("channel", channel.id).Errorln("failed to send group replace to connection")
+	}
+}
+
+func (m *Manager) onAfterGroupAddOrRemove(channel *Channel, op ChannelOp, id string) {
+	members, connections := channel.Connections()
+
+	data := &api.RTMDataWebRTCChannelExtra{}
+	data.Group = &api.RTMTDataWebRTCChannelGroup{
+		Group:      channel.config.Group,
+		Members:    members,
+		Reset:      op == ChannelOpReset,
+		Moderators: m.getChannelModerators(channel),
+	}
+	payload, err := m.encodeGroupChannelPayload(channel, data)
 	if err != nil {
 		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode group channel data")
 		return
 	}
+	// Members with support for profiles get their own payload.
+	var payloadWithProfiles []byte
 
 	idx := 0
 	for _, cid := range members {
@@ -92,13 +243,42 @@ func (m *Manager) onAfterGroupAddOrRemove(channel *Channel, op ChannelOp, id str
 			continue
 		}
 
-		err = c.RawSend(payload)
+		if channel.wantsProfiles(cid) {
+			if payloadWithProfiles == nil {
+				data.Group.Profiles = m.getChannelProfiles(members, connections)
+				payloadWithProfiles, err = m.encodeGroupChannelPayload(channel, data)
+				if err != nil {
+					m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode group channel data with profiles")
+					return
+				}
+			}
+			err = c.RawSend(payloadWithProfiles)
+		} else {
+			err = c.RawSend(payload)
+		}
 		if err != nil {
 			c.Logger().WithError(err).WithField("channel", channel.id).Errorln("failed to send group channel to connection")
 		}
 	}
 }
 
+func (m *Manager) encodeGroupChannelPayload(channel *Channel, data *api.RTMDataWebRTCChannelExtra) ([]byte, error) {
+	extra, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(&api.RTMTypeWebRTCReply{
+		RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCChannel,
+		},
+		Channel: channel.id,
+		Data:    extra,
+		Version: currentWebRTCPayloadVersion,
+	}, "", "\t")
+}
+
 func (m *Manager) onAfterGroupChannelReset(channel *Channel) {
 	members, connections := channel.Connections()
 
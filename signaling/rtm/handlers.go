@@ -102,6 +102,7 @@ func (m *Manager) HandleWebsocketConnect(ctx context.Context, key string, rw htt
 		return err
 	}
 	c.Bind(kr.user)
+	m.requestSession(c, kr.user.id, kr.resume, kr.resumeSeq)
 	go m.serveWebsocketConnection(c, id)
 
 	return nil
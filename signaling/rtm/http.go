@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
@@ -151,6 +152,13 @@ func (m *Manager) isRequestWithValidAuth(req *http.Request) (*api.AdminAuthToken
 					m.logger.Warnln("rtm connect as guest but guest support is disabled")
 					return nil, false
 				}
+			} else {
+				// Moderator support, guests are never moderators by claim.
+				applyModeratorClaim(auth, (*claims)[api.ModeratorClaim])
+				// Participant limit for groups created by the user.
+				if maxParticipants, ok := (*claims)[api.MaxParticipantsClaim].(float64); ok && maxParticipants > 0 {
+					auth.MaxParticipants = int(maxParticipants)
+				}
 			}
 
 			return auth, true
@@ -189,6 +197,11 @@ func (m *Manager) MakeHTTPConnectHandler(router *mux.Router, websocketRouteIdent
 			return
 		}
 
+		// No new connections while draining.
+		if m.refuseWhenDraining(rw) {
+			return
+		}
+
 		if !m.insecure && user != auth.Subject {
 			http.Error(rw, "user does not match auth", http.StatusForbidden)
 			return
@@ -196,8 +209,11 @@ func (m *Manager) MakeHTTPConnectHandler(router *mux.Router, websocketRouteIdent
 
 		m.adminm.RefreshAdminAuthToken(auth)
 
+		// Sequence of the last message seen by the client, if resuming.
+		resumeSeq, _ := strconv.ParseUint(req.Form.Get("resume_seq"), 10, 64)
+
 		// create random URL to websocket endpoint
-		key, err := m.Connect(req.Context(), user, auth)
+		key, err := m.Connect(req.Context(), user, auth, req.Form.Get("resume"), resumeSeq)
 		if err != nil {
 			m.logger.WithError(err).Errorln("rtm connect failed")
 			http.Error(rw, "request failed", http.StatusInternalServerError)
@@ -238,7 +254,7 @@ func (m *Manager) MakeHTTPConnectHandler(router *mux.Router, websocketRouteIdent
 
 			TURN: turnConfig,
 
-			ServerStatus: m.getServerStatus(),
+			ServerStatus: m.getServerStatusForUser(user),
 		}
 
 		rw.Header().Set("Content-Type", "application/json")
@@ -303,6 +319,11 @@ func (m *Manager) HTTPWebsocketHandler(rw http.ResponseWriter, req *http.Request
 		return
 	}
 
+	// Keys created before draining started are refused as well.
+	if m.refuseWhenDraining(rw) {
+		return
+	}
+
 	vars := mux.Vars(req)
 	key, ok := vars["key"]
 	if !ok {
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+)
+
+// maxCallTargets is the maximum number of users a single call can ring.
+const maxCallTargets = 32
+
+// parseHuntGroup parses the provided hunt group value with the format
+// NAME=USER[,USER...] into its name and users.
+func parseHuntGroup(value string) (string, []string, error) {
+	idx := strings.Index(value, "=")
+	if idx < 1 {
+		return "", nil, fmt.Errorf("invalid hunt group: %v", value)
+	}
+	users := make([]string, 0)
+	for _, user := range strings.Split(value[idx+1:], ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			users = append(users, user)
+		}
+	}
+	if len(users) == 0 || len(users) > maxCallTargets {
+		return "", nil, fmt.Errorf("invalid hunt group users: %v", value)
+	}
+
+	return value[:idx], users, nil
+}
+
+// SetHuntGroups sets the named groups of users which can be called in
+// parallel from the provided values, see parseHuntGroup for their format.
+func (m *Manager) SetHuntGroups(values []string) error {
+	huntGroups := make(map[string][]string)
+	for _, value := range values {
+		name, users, err := parseHuntGroup(value)
+		if err != nil {
+			return err
+		}
+		if _, exists := huntGroups[name]; exists {
+			return fmt.Errorf("duplicate hunt group: %v", name)
+		}
+		huntGroups[name] = users
+	}
+
+	m.huntGroups = huntGroups
+	if len(huntGroups) > 0 {
+		m.logger.WithField("groups", len(huntGroups)).Infoln("hunt groups enabled")
+	}
+
+	return nil
+}
+
+// getCallTargets returns the users to ring for the provided call request of
+// source with multiple targets.
+func (m *Manager) getCallTargets(source string, msg *api.RTMTypeWebRTC) ([]string, error) {
+	users := msg.Targets
+	if msg.Hunt != "" {
+		huntUsers, ok := m.huntGroups[msg.Hunt]
+		if !ok {
+			return nil, api.NewRTMTypeError(api.RTMErrorIDBadMessage, "unknown hunt group", msg.ID)
+		}
+		users = append(huntUsers[:len(huntUsers):len(huntUsers)], users...)
+	}
+
+	seen := make(map[string]bool)
+	targets := make([]string, 0, len(users))
+	for _, user := range users {
+		if user == "" || user == source || seen[user] {
+			continue
+		}
+		seen[user] = true
+		targets = append(targets, user)
+	}
+	if len(targets) == 0 {
+		return nil, api.NewRTMTypeError(api.RTMErrorIDBadMessage, "no targets", msg.ID)
+	}
+	if len(targets) > maxCallTargets {
+		return nil, api.NewRTMTypeError(api.RTMErrorIDBadMessage, "too many targets", msg.ID)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"source":  source,
+		"hunt":    msg.Hunt,
+		"targets": len(targets),
+	}).Debugln("call with multiple targets")
+
+	return targets, nil
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+)
+
+func TestParseHuntGroup(t *testing.T) {
+	tooMany := "support="
+	for i := 0; i <= maxCallTargets; i++ {
+		tooMany += "user" + strconv.Itoa(i) + ","
+	}
+
+	tests := []struct {
+		value   string
+		name    string
+		users   []string
+		success bool
+	}{
+		{"support=user1,user2", "support", []string{"user1", "user2"}, true},
+		{"support= user1 , ,user2,", "support", []string{"user1", "user2"}, true},
+		{"support=user1", "support", []string{"user1"}, true},
+		{"support=", "", nil, false},
+		{"support= , ", "", nil, false},
+		{"=user1", "", nil, false},
+		{"support", "", nil, false},
+		{tooMany, "", nil, false},
+	}
+
+	for idx, test := range tests {
+		name, users, err := parseHuntGroup(test.value)
+		if (err == nil) != test.success {
+			t.Errorf("%d: unexpected result %v", idx, err)
+			continue
+		}
+		if name != test.name || !reflect.DeepEqual(users, test.users) {
+			t.Errorf("%d: got %q %v, expected %q %v", idx, name, users, test.name, test.users)
+		}
+	}
+}
+
+func TestGetCallTargets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := newTestManager(ctx)
+	if err := m.SetHuntGroups([]string{"support=user2,user3", "self=user1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tooMany := make([]string, 0, maxCallTargets+1)
+	for i := 0; i <= maxCallTargets; i++ {
+		tooMany = append(tooMany, "target"+strconv.Itoa(i))
+	}
+
+	tests := []struct {
+		targets []string
+		hunt    string
+		result  []string
+		success bool
+	}{
+		{[]string{"user2", "user3"}, "", []string{"user2", "user3"}, true},
+		{[]string{"user2", "", "user2", "user1", "user3"}, "", []string{"user2", "user3"}, true},
+		{nil, "support", []string{"user2", "user3"}, true},
+		{[]string{"user4", "user2"}, "support", []string{"user2", "user3", "user4"}, true},
+		{nil, "unknown", nil, false},
+		{nil, "self", nil, false},
+		{[]string{"user1"}, "", nil, false},
+		{tooMany, "", nil, false},
+	}
+
+	for idx, test := range tests {
+		result, err := m.getCallTargets("user1", &api.RTMTypeWebRTC{
+			RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+				Type:    api.RTMTypeNameWebRTC,
+				Subtype: api.RTMSubtypeNameWebRTCCall,
+			},
+			Targets: test.targets,
+			Hunt:    test.hunt,
+		})
+		if (err == nil) != test.success {
+			t.Errorf("%d: unexpected result %v", idx, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.result) {
+			t.Errorf("%d: targets %v, expected %v", idx, result, test.result)
+		}
+	}
+
+	// Hunt group users are never modified.
+	if users := m.huntGroups["support"]; !reflect.DeepEqual(users, []string{"user2", "user3"}) {
+		t.Errorf("hunt group modified: %v", users)
+	}
+}
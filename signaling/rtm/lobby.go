@@ -0,0 +1,287 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// maximalChannelLobbySize is the maximum number of joiners which can wait in
+// the lobby of a group channel.
+const maximalChannelLobbySize = 100
+
+// lobbyEntry is a joiner waiting in the lobby of a group channel together with
+// its group message, which is completed when the joiner gets admitted.
+type lobbyEntry struct {
+	connection *connection.Connection
+	msg        *api.RTMTypeWebRTC
+	auth       *api.AdminAuthToken
+	profile    *api.RTMDataProfile
+	when       time.Time
+}
+
+// isAdmitted returns true if the member identified by id was admitted to the
+// accociated channel before.
+func (c *Channel) isAdmitted(id string) bool {
+	c.RLock()
+	admitted := c.admitted[id]
+	c.RUnlock()
+
+	return admitted
+}
+
+// needsGroupLobby returns true if the user of the provided user record has to
+// wait in the lobby of the provided group channel before joining it.
+func (m *Manager) needsGroupLobby(channel *Channel, ur *userRecord, auth *api.AdminAuthToken) bool {
+	if m.groupLobbyPattern == nil || !m.groupLobbyPattern.MatchString(channel.config.Group) {
+		return false
+	}
+
+	guest := auth != nil && auth.IsGuest()
+	if !guest {
+		if m.groupLobbyGuestsOnly || m.isChannelModerator(channel, ur) {
+			return false
+		}
+		if channel.Size() == 0 {
+			// Nobody there who could admit.
+			return false
+		}
+	}
+
+	return !channel.isAdmitted(ur.id)
+}
+
+// enterGroupLobby puts the provided connection into the lobby of the provided
+// group channel and notifies the joiner and all members.
+func (m *Manager) enterGroupLobby(c *connection.Connection, msg *api.RTMTypeWebRTC, ur *userRecord, channel *Channel) error {
+	entry := &lobbyEntry{
+		connection: c,
+		msg:        msg,
+		auth:       ur.auth,
+		profile:    &api.RTMDataProfile{},
+		when:       time.Now(),
+	}
+	if ur.auth != nil {
+		entry.profile.Name = ur.auth.Name()
+	}
+
+	channel.Lock()
+	existing, exists := channel.lobby[ur.id]
+	if !exists && len(channel.lobby) >= maximalChannelLobbySize {
+		channel.Unlock()
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "lobby is full", msg.ID)
+	}
+	channel.lobby[ur.id] = entry
+	channel.Unlock()
+
+	if !exists || existing.connection != c {
+		// Register only once per entry, joiners might retry while waiting.
+		c.OnClosed(func(conn *connection.Connection) {
+			m.leaveGroupLobby(channel, ur.id, conn)
+		})
+	}
+
+	c.Logger().WithField("channel", channel.id).Debugln("channel lobby enter")
+	m.sendGroupLobbyState(channel, entry, api.RTMLobbyStateWaiting)
+	m.sendGroupLobby(channel, true)
+
+	return nil
+}
+
+// leaveGroupLobby removes the member identified by id from the lobby of the
+// provided channel, if it is waiting with the provided connection.
+func (m *Manager) leaveGroupLobby(channel *Channel, id string, c *connection.Connection) {
+	channel.Lock()
+	entry, ok := channel.lobby[id]
+	if !ok || entry.connection != c {
+		channel.Unlock()
+		return
+	}
+	delete(channel.lobby, id)
+	channel.Unlock()
+
+	m.sendGroupLobby(channel, true)
+}
+
+// takeGroupLobbyEntry removes and returns the lobby entry of the member
+// identified by id. If admit is true, the member is remembered as admitted.
+func (c *Channel) takeGroupLobbyEntry(id string, admit bool) *lobbyEntry {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, ok := c.lobby[id]
+	if !ok {
+		return nil
+	}
+	delete(c.lobby, id)
+	if admit {
+		c.admitted[id] = true
+	}
+	return entry
+}
+
+func (m *Manager) processWebRTCLobby(c *connection.Connection, msg *api.RTMTypeWebRTC, ur *userRecord) error {
+	// Connection must have a user.
+	if ur == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection has no user", msg.ID)
+	}
+	// Channel and target must not be empty.
+	if msg.Channel == "" || msg.Target == "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel or target is empty", msg.ID)
+	}
+	// Source must always be empty when received here.
+	if msg.Source != "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "source must be empty", msg.ID)
+	}
+
+	// Get channel, only group channels have a lobby.
+	record, ok := m.channels.Get(msg.Channel)
+	if !ok {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel not found", msg.ID)
+	}
+	channel := record.(*channelRecord).channel
+	if channel.config.Group == "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel has no lobby", msg.ID)
+	}
+
+	// Receiving connection must be in channel, waiting joiners cannot admit.
+	if cc, _ := channel.Get(ur.id); cc != c {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection not in channel", msg.ID)
+	}
+
+	admit := msg.Subtype == api.RTMSubtypeNameWebRTCAdmit
+	entry := channel.takeGroupLobbyEntry(msg.Target, admit)
+	if entry == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "target not in lobby", msg.ID)
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"channel": channel.id,
+		"target":  msg.Target,
+		"admit":   admit,
+	}).Debugln("channel lobby leave")
+
+	if admit {
+		m.sendGroupLobbyState(channel, entry, api.RTMLobbyStateAdmitted)
+		tur, _ := entry.connection.Bound().(*userRecord)
+		if tur != nil && tur.id == msg.Target {
+			if err := m.joinGroupChannel(entry.connection, entry.msg, tur, entry.auth, channel); err != nil {
+				m.OnError(entry.connection, err)
+			}
+		}
+	} else {
+		m.sendGroupLobbyState(channel, entry, api.RTMLobbyStateDenied)
+	}
+	m.sendGroupLobby(channel, true)
+
+	return c.Send(&api.RTMTypeWebRTCReply{
+		RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: msg.Subtype,
+			ReplyTo: msg.ID,
+		},
+		Channel: channel.id,
+		Version: currentWebRTCPayloadVersion,
+	})
+}
+
+// sendGroupLobbyState sends the provided lobby state to the joiner of the
+// provided lobby entry.
+func (m *Manager) sendGroupLobbyState(channel *Channel, entry *lobbyEntry, state string) {
+	data, err := json.MarshalIndent(&api.RTMDataWebRTCLobby{
+		State: state,
+	}, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode group lobby state data")
+		return
+	}
+
+	err = entry.connection.Send(&api.RTMTypeWebRTC{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCLobby,
+		},
+		Channel: channel.id,
+		Group:   channel.config.Group,
+		Version: currentWebRTCPayloadVersion,
+		Data:    data,
+	})
+	if err != nil {
+		entry.connection.Logger().WithError(err).WithField("channel", channel.id).Debugln("failed to send group lobby state to connection")
+	}
+}
+
+// sendGroupLobby sends the joiners waiting in the lobby of the provided
+// channel to all its members. Unless always is true, nothing is sent when the
+// lobby is empty.
+func (m *Manager) sendGroupLobby(channel *Channel, always bool) {
+	channel.RLock()
+	pending := make([]*api.RTMDataWebRTCLobbyEntry, 0, len(channel.lobby))
+	for id, entry := range channel.lobby {
+		pending = append(pending, &api.RTMDataWebRTCLobbyEntry{
+			User:    id,
+			Profile: entry.profile,
+			TS:      entry.when.Unix(),
+		})
+	}
+	channel.RUnlock()
+
+	if len(pending) == 0 && !always {
+		return
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].TS < pending[j].TS
+	})
+
+	data, err := json.MarshalIndent(&api.RTMDataWebRTCLobby{
+		Pending: pending,
+	}, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode group lobby data")
+		return
+	}
+	payload, err := json.MarshalIndent(&api.RTMTypeWebRTC{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCLobby,
+		},
+		Channel: channel.id,
+		Group:   channel.config.Group,
+		Version: currentWebRTCPayloadVersion,
+		Data:    data,
+	}, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode group lobby")
+		return
+	}
+
+	_, connections := channel.Connections()
+	for _, connection := range connections {
+		err = connection.RawSend(payload)
+		if err != nil {
+			connection.Logger().WithError(err).WithField("channel", channel.id).Debugln("failed to send group lobby to connection")
+		}
+	}
+}
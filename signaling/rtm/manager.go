@@ -19,6 +19,7 @@ package rtm
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"regexp"
 	"sync"
@@ -33,9 +34,11 @@ import (
 
 	"stash.kopano.io/kwm/kwmserver/signaling/admin"
 	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/chats"
 	"stash.kopano.io/kwm/kwmserver/signaling/connection"
 	"stash.kopano.io/kwm/kwmserver/signaling/guest"
 	"stash.kopano.io/kwm/kwmserver/signaling/mcu"
+	"stash.kopano.io/kwm/kwmserver/signaling/meetings"
 	"stash.kopano.io/kwm/kwmserver/turn"
 )
 
@@ -64,6 +67,52 @@ type Manager struct {
 	connections cmap.ConcurrentMap
 	users       cmap.ConcurrentMap
 	channels    cmap.ConcurrentMap
+
+	sessions           cmap.ConcurrentMap
+	connectionSessions cmap.ConcurrentMap
+	pendingSessions    cmap.ConcurrentMap
+	sessionResumeGrace time.Duration
+	sessionReplaySize  int
+
+	presenceSubscribers   cmap.ConcurrentMap
+	presenceSubscriptions cmap.ConcurrentMap
+
+	connectionsPerUserMax    int
+	connectionsPerUserPolicy string
+
+	groupLobbyPattern    *regexp.Regexp
+	groupLobbyGuestsOnly bool
+
+	groupMaxParticipants int
+	groupLimitRules      []*groupLimitRule
+
+	profileClaims []string
+
+	callRingTimeout time.Duration
+	huntGroups      map[string][]string
+
+	groupPasscodes cmap.ConcurrentMap
+
+	rateLimiters        cmap.ConcurrentMap
+	rateLimitRules      []*RateLimitRule
+	rateLimitDisconnect int
+
+	rateLimitedUsersMutex sync.Mutex
+	rateLimitedUsers      map[string]uint64
+
+	chatsStore          chats.Store
+	chatsRichTextPolicy *chats.RichTextPolicy
+
+	meetingsStore meetings.Store
+
+	noticesMutex sync.RWMutex
+	notices      []*api.AdminServerNotice
+
+	draining        int32
+	drainCh         chan struct{}
+	drainTimeout    time.Duration
+	drainURL        string
+	drainRetryAfter time.Duration
 }
 
 // NewManager creates a new Manager with an id.
@@ -94,6 +143,21 @@ func NewManager(ctx context.Context, id string, insecure bool, requiredScopes []
 		connections: cmap.New(),
 		users:       cmap.New(),
 		channels:    cmap.New(),
+
+		sessions:           cmap.New(),
+		connectionSessions: cmap.New(),
+		pendingSessions:    cmap.New(),
+		sessionResumeGrace: sessionResumeGrace,
+		sessionReplaySize:  sessionReplaySize,
+
+		presenceSubscribers:   cmap.New(),
+		presenceSubscriptions: cmap.New(),
+
+		rateLimiters: cmap.New(),
+
+		groupPasscodes: cmap.New(),
+
+		drainCh: make(chan struct{}),
 	}
 
 	m.serverStatus.Store(&api.ServerStatus{})
@@ -116,7 +180,9 @@ func NewManager(ctx context.Context, id string, insecure bool, requiredScopes []
 			case <-ticker.C:
 				m.purgeExpiredKeys()
 				m.purgeEmptyChannels()
+				m.logRateLimitedUsers()
 				m.purgeInactiveUsers()
+				m.purgeExpiredServerNotices()
 			case <-ctx.Done():
 				return
 			}
@@ -126,9 +192,145 @@ func NewManager(ctx context.Context, id string, insecure bool, requiredScopes []
 	return m
 }
 
+// SetSessionResumeGrace sets the duration for which sessions of dropped
+// connections can be resumed.
+func (m *Manager) SetSessionResumeGrace(grace time.Duration) {
+	m.sessionResumeGrace = grace
+	m.logger.WithField("grace", grace).Debugln("session resume grace set")
+}
+
+// SetSessionReplaySize sets the number of messages per session which are kept
+// to be replayed when the session is resumed. The size must be larger than 0.
+func (m *Manager) SetSessionReplaySize(size int) {
+	m.sessionReplaySize = size
+	m.logger.WithField("size", size).Debugln("session replay size set")
+}
+
+// SetConnectionLimit sets the maximum number of concurrent connections per user
+// and the policy which is applied when a user exceeds it. A max value of 0
+// disables the limit.
+func (m *Manager) SetConnectionLimit(max int, policy string) error {
+	switch policy {
+	case ConnectionLimitPolicyReject, ConnectionLimitPolicyEvictOldest, ConnectionLimitPolicyEvictIdle:
+	default:
+		return fmt.Errorf("unknown connection limit policy: %v", policy)
+	}
+	if max < 0 {
+		return fmt.Errorf("invalid connection limit: %d", max)
+	}
+
+	m.connectionsPerUserMax = max
+	m.connectionsPerUserPolicy = policy
+	if max > 0 {
+		m.logger.WithFields(logrus.Fields{
+			"max":    max,
+			"policy": policy,
+		}).Infoln("per user connection limit enabled")
+	}
+
+	return nil
+}
+
+// SetGroupLobby enables the lobby for all groups matching the provided pattern
+// regular expression. If guestsOnly is true, only guests have to wait in the
+// lobby.
+func (m *Manager) SetGroupLobby(patternString string, guestsOnly bool) error {
+	pattern, err := regexp.Compile(patternString)
+	if err != nil {
+		return err
+	}
+
+	m.groupLobbyPattern = pattern
+	m.groupLobbyGuestsOnly = guestsOnly
+	m.logger.WithFields(logrus.Fields{
+		"pattern":     pattern.String(),
+		"guests_only": guestsOnly,
+	}).Infoln("group lobby enabled")
+
+	return nil
+}
+
+// SetGroupMaxParticipants sets the maximum number of participants for group
+// channels. The provided rule string values set the maximum for groups with
+// matching IDs with the format `REGEXP=MAX`, the first match wins. Groups
+// without matching rule use the provided max value. 0 means unlimited.
+func (m *Manager) SetGroupMaxParticipants(max int, values []string) error {
+	if max < 0 {
+		return fmt.Errorf("invalid group max participants: %d", max)
+	}
+	rules := make([]*groupLimitRule, 0, len(values))
+	for _, value := range values {
+		rule, err := parseGroupLimitRule(value)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+
+	m.groupMaxParticipants = max
+	m.groupLimitRules = rules
+	if max > 0 || len(rules) > 0 {
+		m.logger.WithFields(logrus.Fields{
+			"max":   max,
+			"rules": len(rules),
+		}).Infoln("group participant limits enabled")
+	}
+
+	return nil
+}
+
+// SetProfileClaims sets the claims of authenticated users which are exposed
+// to other users as part of the users profile.
+func (m *Manager) SetProfileClaims(claims []string) {
+	m.profileClaims = claims
+}
+
+// SetRateLimits sets the rate limit rules for incoming messages from the
+// provided rule string values, see ParseRateLimitRule for their format.
+// Connections which hit the limits disconnect or more times within a minute are
+// disconnected, a disconnect value of 0 disables this.
+func (m *Manager) SetRateLimits(values []string, disconnect int) error {
+	rules := make([]*RateLimitRule, 0, len(values))
+	for _, value := range values {
+		rule, err := ParseRateLimitRule(value)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	if disconnect < 0 {
+		return fmt.Errorf("invalid rate limit disconnect threshold: %d", disconnect)
+	}
+
+	m.rateLimitRules = rules
+	m.rateLimitDisconnect = disconnect
+	if len(rules) > 0 {
+		m.logger.WithFields(logrus.Fields{
+			"rules":      len(rules),
+			"disconnect": disconnect,
+		}).Infoln("rate limits enabled")
+	}
+
+	return nil
+}
+
+// SetChatsStore sets the store which is used to record chats messages and to
+// provide chats history.
+func (m *Manager) SetChatsStore(store chats.Store) {
+	m.chatsStore = store
+}
+
+// SetChatsRichTextPolicy sets the policy which is used to sanitize the rich
+// text of chats messages. If nil, rich text is relayed as is.
+func (m *Manager) SetChatsRichTextPolicy(policy *chats.RichTextPolicy) {
+	m.chatsRichTextPolicy = policy
+}
+
 type keyRecord struct {
-	when time.Time
-	user *userRecord
+	when      time.Time
+	user      *userRecord
+	resume    string
+	resumeSeq uint64
 }
 
 func (m *Manager) purgeExpiredKeys() {
@@ -153,6 +355,12 @@ type userRecord struct {
 	when        time.Time
 	exit        time.Time
 	connections []*connection.Connection
+
+	status     string
+	statusText string
+	statusWhen time.Time
+
+	limiter *rateLimiter
 }
 
 func (m *Manager) purgeInactiveUsers() {
@@ -214,6 +422,9 @@ func (m *Manager) purgeEmptyChannels() {
 		if record.channel.Cleanup() {
 			m.logger.WithField("channel", entry.Key).Debugln("channel purge")
 			m.channels.Remove(entry.Key)
+			if group := record.channel.config.Group; group != "" {
+				m.removeModeratorGroupPasscode(group)
+			}
 		}
 	}
 }
@@ -230,13 +441,18 @@ func (m *Manager) setServerStatus(serverStatus *api.ServerStatus) {
 	m.serverStatus.Store(serverStatus)
 }
 
-// Connect adds a new connect entry to the managers table with random key.
-func (m *Manager) Connect(ctx context.Context, userID string, auth *api.AdminAuthToken) (string, error) {
+// Connect adds a new connect entry to the managers table with random key. If
+// resume is not empty, it is used to resume a previous session when the
+// websocket for the returned key connects. All messages of that session with
+// a sequence number higher than resumeSeq are replayed.
+func (m *Manager) Connect(ctx context.Context, userID string, auth *api.AdminAuthToken, resume string, resumeSeq uint64) (string, error) {
 	key := rndm.GenerateRandomString(connectKeySize)
 
 	// Add key to table.
 	record := &keyRecord{
-		when: time.Now(),
+		when:      time.Now(),
+		resume:    resume,
+		resumeSeq: resumeSeq,
 	}
 	if userID != "" {
 		record.user = &userRecord{
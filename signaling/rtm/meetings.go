@@ -0,0 +1,219 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"stash.kopano.io/kgol/rndm"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/meetings"
+)
+
+// SetMeetingsStore sets the store which holds scheduled meetings. Joins to
+// groups with scheduled meetings are only possible while a meeting is active.
+func (m *Manager) SetMeetingsStore(store meetings.Store) {
+	m.meetingsStore = store
+}
+
+// getGroupMeeting returns the active meeting of the group with the provided
+// ID. It returns nil if the group has no scheduled meetings.
+func (m *Manager) getGroupMeeting(msg *api.RTMTypeWebRTC) (*meetings.Meeting, error) {
+	if m.meetingsStore == nil {
+		return nil, nil
+	}
+
+	scheduled, err := m.meetingsStore.List(m.ctx, &meetings.ListQuery{
+		Group: msg.Group,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list meetings: %v", err)
+	}
+	if len(scheduled) == 0 {
+		return nil, nil
+	}
+
+	meeting := meetings.FindActive(scheduled, time.Now())
+	if meeting == nil {
+		return nil, api.NewRTMTypeError(api.RTMErrorIDMeetingNotActive, "meeting is not active", msg.ID)
+	}
+
+	return meeting, nil
+}
+
+// AddMeetingsRoutes adds the scheduled meetings HTTP routes to the provided
+// router, wrapped with the provided wrapper.
+func (m *Manager) AddMeetingsRoutes(ctx context.Context, router *mux.Router, wrapper func(http.Handler) http.Handler) http.Handler {
+	router.Handle("", wrapper(http.HandlerFunc(m.listMeetingsHandler))).Methods(http.MethodGet)
+	router.Handle("", wrapper(http.HandlerFunc(m.createMeetingHandler))).Methods(http.MethodPost)
+	router.Handle("/{id}", wrapper(http.HandlerFunc(m.getMeetingHandler))).Methods(http.MethodGet)
+	router.Handle("/{id}", wrapper(http.HandlerFunc(m.updateMeetingHandler))).Methods(http.MethodPut)
+	router.Handle("/{id}", wrapper(http.HandlerFunc(m.removeMeetingHandler))).Methods(http.MethodDelete)
+
+	return router
+}
+
+func (m *Manager) checkMeetingsStore(rw http.ResponseWriter) bool {
+	if m.meetingsStore == nil {
+		http.Error(rw, "meetings are not enabled", http.StatusNotImplemented)
+		return false
+	}
+
+	return true
+}
+
+func (m *Manager) readMeeting(rw http.ResponseWriter, req *http.Request) (*meetings.Meeting, bool) {
+	msg, err := ioutil.ReadAll(io.LimitReader(req.Body, maxAdminRequestSize))
+	if err != nil {
+		m.logger.WithError(err).Debugln("failed to read request body")
+		http.Error(rw, fmt.Errorf("failed to read request: %v", err).Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	var meeting meetings.Meeting
+	err = json.Unmarshal(msg, &meeting)
+	if err != nil {
+		m.logger.WithError(err).Debugln("failed to parse request")
+		http.Error(rw, fmt.Errorf("failed to parse: %v", err).Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err = meeting.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &meeting, true
+}
+
+func (m *Manager) writeMeetingsError(rw http.ResponseWriter, req *http.Request, err error) {
+	switch err {
+	case meetings.ErrMeetingNotFound:
+		http.NotFound(rw, req)
+	case meetings.ErrMeetingExists:
+		http.Error(rw, err.Error(), http.StatusConflict)
+	default:
+		m.logger.WithError(err).Errorln("meetings store request failed")
+		http.Error(rw, "meetings store request failed", http.StatusInternalServerError)
+	}
+}
+
+func (m *Manager) listMeetingsHandler(rw http.ResponseWriter, req *http.Request) {
+	if !m.checkMeetingsStore(rw) {
+		return
+	}
+
+	result, err := m.meetingsStore.List(req.Context(), &meetings.ListQuery{
+		Group: req.URL.Query().Get("group"),
+	})
+	if err != nil {
+		m.writeMeetingsError(rw, req, err)
+		return
+	}
+
+	writeAdminResponse(rw, http.StatusOK, result)
+}
+
+func (m *Manager) createMeetingHandler(rw http.ResponseWriter, req *http.Request) {
+	if !m.checkMeetingsStore(rw) {
+		return
+	}
+	meeting, ok := m.readMeeting(rw, req)
+	if !ok {
+		return
+	}
+
+	if meeting.ID == "" {
+		meeting.ID = rndm.GenerateRandomString(32)
+	}
+	meeting.Created = time.Now().Unix()
+	meeting.Updated = meeting.Created
+
+	if err := m.meetingsStore.Add(req.Context(), meeting); err != nil {
+		m.writeMeetingsError(rw, req, err)
+		return
+	}
+
+	writeAdminResponse(rw, http.StatusCreated, meeting)
+}
+
+func (m *Manager) getMeetingHandler(rw http.ResponseWriter, req *http.Request) {
+	if !m.checkMeetingsStore(rw) {
+		return
+	}
+
+	meeting, err := m.meetingsStore.Get(req.Context(), mux.Vars(req)["id"])
+	if err != nil {
+		m.writeMeetingsError(rw, req, err)
+		return
+	}
+
+	writeAdminResponse(rw, http.StatusOK, meeting)
+}
+
+func (m *Manager) updateMeetingHandler(rw http.ResponseWriter, req *http.Request) {
+	if !m.checkMeetingsStore(rw) {
+		return
+	}
+	meeting, ok := m.readMeeting(rw, req)
+	if !ok {
+		return
+	}
+
+	id := mux.Vars(req)["id"]
+	if meeting.ID != "" && meeting.ID != id {
+		http.Error(rw, "id mismatch", http.StatusBadRequest)
+		return
+	}
+	existing, err := m.meetingsStore.Get(req.Context(), id)
+	if err != nil {
+		m.writeMeetingsError(rw, req, err)
+		return
+	}
+
+	meeting.ID = id
+	meeting.Created = existing.Created
+	meeting.Updated = time.Now().Unix()
+
+	if err = m.meetingsStore.Update(req.Context(), meeting); err != nil {
+		m.writeMeetingsError(rw, req, err)
+		return
+	}
+
+	writeAdminResponse(rw, http.StatusOK, meeting)
+}
+
+func (m *Manager) removeMeetingHandler(rw http.ResponseWriter, req *http.Request) {
+	if !m.checkMeetingsStore(rw) {
+		return
+	}
+
+	if err := m.meetingsStore.Remove(req.Context(), mux.Vars(req)["id"]); err != nil {
+		m.writeMeetingsError(rw, req, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
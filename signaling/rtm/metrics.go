@@ -75,6 +75,30 @@ var (
 		},
 		[]string{"id"},
 	)
+	connectionEvicted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: metricsSubsystem,
+			Name:      "connections_evicted_total",
+			Help:      "Total number of RTM connections evicted by the per user connection limit",
+		},
+		[]string{"id", "policy"},
+	)
+	connectionRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: metricsSubsystem,
+			Name:      "connections_rejected_total",
+			Help:      "Total number of RTM connections rejected by the per user connection limit",
+		},
+		[]string{"id"},
+	)
+	rateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: metricsSubsystem,
+			Name:      "messages_rate_limited_total",
+			Help:      "Total number of incoming RTM messages refused by rate limits",
+		},
+		[]string{"id", "type"},
+	)
 	userNew = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Subsystem: metricsSubsystem,
@@ -127,6 +151,9 @@ func MustRegister(reg prometheus.Registerer, cs ...prometheus.Collector) {
 		channelRemove,
 		connectionAdd,
 		connectionRemove,
+		connectionEvicted,
+		connectionRejected,
+		rateLimited,
 		userNew,
 		userCleanup,
 		httpRequestSuccessConnect,
@@ -155,6 +182,9 @@ type managerCollector struct {
 	connectionsMaxCountDesc    *prometheus.Desc
 	connectionsCountMaxCounter counter.UintMinMax
 
+	groupChannelsParticipantsDesc      *prometheus.Desc
+	groupChannelsParticipantsLimitDesc *prometheus.Desc
+
 	usersCountDesc       *prometheus.Desc
 	usersCountMaxDesc    *prometheus.Desc
 	usersCountMaxCounter counter.UintMinMax
@@ -222,6 +252,19 @@ func NewManagerCollector(manager *Manager) prometheus.Collector {
 		),
 		connectionsCountMaxCounter: counter.GetUintMax(),
 
+		groupChannelsParticipantsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName("", metricsSubsystem, "group_channel_participants_current"),
+			"Current number of participants of RTM group channels with participant limit",
+			[]string{"id", "group"},
+			nil,
+		),
+		groupChannelsParticipantsLimitDesc: prometheus.NewDesc(
+			prometheus.BuildFQName("", metricsSubsystem, "group_channel_participants_limit"),
+			"Maximum number of participants of RTM group channels with participant limit",
+			[]string{"id", "group"},
+			nil,
+		),
+
 		usersCountDesc: prometheus.NewDesc(
 			prometheus.BuildFQName("", metricsSubsystem, "distinct_users_connected_current"),
 			"Current number of concurrent distinct users connected to RTM",
@@ -243,6 +286,9 @@ func NewManagerCollector(manager *Manager) prometheus.Collector {
 // descriptors.
 func (mc *managerCollector) Describe(ch chan<- *prometheus.Desc) {
 	prometheus.DescribeByCollect(mc, ch)
+	// Per group metrics are only collected while there are such groups.
+	ch <- mc.groupChannelsParticipantsDesc
+	ch <- mc.groupChannelsParticipantsLimitDesc
 }
 
 // Collect first gathers the associated managers collectors managers data. Then
@@ -289,8 +335,25 @@ func (mc *managerCollector) Collect(ch chan<- prometheus.Metric) {
 		if cr.channel.config.Group != "" {
 			numGroupChannels++
 			cr.channel.RLock()
-			numGroupChannelsConnections += uint64(len(cr.channel.connections))
+			numParticipants := len(cr.channel.connections)
 			cr.channel.RUnlock()
+			numGroupChannelsConnections += uint64(numParticipants)
+			if cr.channel.config.MaxParticipants > 0 {
+				ch <- prometheus.MustNewConstMetric(
+					mc.groupChannelsParticipantsDesc,
+					prometheus.GaugeValue,
+					float64(numParticipants),
+					mc.m.id,
+					cr.channel.config.Group,
+				)
+				ch <- prometheus.MustNewConstMetric(
+					mc.groupChannelsParticipantsLimitDesc,
+					prometheus.GaugeValue,
+					float64(cr.channel.config.MaxParticipants),
+					mc.m.id,
+					cr.channel.config.Group,
+				)
+			}
 		}
 		numAllChannels++
 	}
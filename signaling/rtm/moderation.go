@@ -0,0 +1,344 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// channelKickBlockDuration is the default duration for which a kicked
+// participant cannot rejoin a group channel.
+const channelKickBlockDuration = time.Duration(5) * time.Minute
+
+// maximalChannelKickBlockDuration is the maximum duration for which a kicked
+// participant can be blocked from rejoining a group channel.
+const maximalChannelKickBlockDuration = time.Duration(24) * time.Hour
+
+// applyModeratorClaim sets the moderator fields of the provided auth from the
+// provided moderator claim value, which is either a boolean to moderate all
+// groups or a list of group IDs.
+func applyModeratorClaim(auth *api.AdminAuthToken, value interface{}) {
+	switch v := value.(type) {
+	case bool:
+		auth.Moderator = v
+	case []interface{}:
+		auth.ModeratorGroups = make(map[string]bool)
+		for _, group := range v {
+			if s, ok := group.(string); ok && s != "" {
+				auth.ModeratorGroups[s] = true
+			}
+		}
+	}
+}
+
+// SetModerator assigns or revokes the moderator role of the member identified
+// by id.
+func (c *Channel) SetModerator(id string, moderator bool) {
+	c.Lock()
+	if moderator {
+		c.moderators[id] = true
+	} else {
+		delete(c.moderators, id)
+	}
+	c.Unlock()
+}
+
+// setModeratorIfNone assigns the moderator role to the member identified by
+// id, if no member of the accociated channel has the moderator role assigned.
+func (c *Channel) setModeratorIfNone(id string) bool {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.moderators) > 0 {
+		return false
+	}
+	c.moderators[id] = true
+	return true
+}
+
+// passModerator assigns the moderator role to the remaining non guest member
+// with the lowest ID, if no member of the accociated channel has the moderator
+// role assigned. The channel lock must be held.
+func (c *Channel) passModerator() {
+	if len(c.moderators) > 0 || c.config.Group == "" {
+		return
+	}
+
+	members := make([]string, 0, len(c.connections))
+	for id, connection := range c.connections {
+		ur, _ := connection.Bound().(*userRecord)
+		if ur == nil || (ur.auth != nil && ur.auth.IsGuest()) {
+			continue
+		}
+		members = append(members, id)
+	}
+	if len(members) == 0 {
+		return
+	}
+	sort.Strings(members)
+	c.moderators[members[0]] = true
+	c.logger.WithField("id", members[0]).Debugln("channel moderator passed on")
+}
+
+// IsModerator returns true if the member identified by id has the moderator
+// role assigned in the accociated channel.
+func (c *Channel) IsModerator(id string) bool {
+	c.RLock()
+	moderator := c.moderators[id]
+	c.RUnlock()
+
+	return moderator
+}
+
+// block prevents the member identified by id from joining the accociated
+// channel until the provided time.
+func (c *Channel) block(id string, until time.Time) {
+	c.Lock()
+	c.blocked[id] = until
+	delete(c.admitted, id)
+	c.Unlock()
+}
+
+// isBlocked returns true if the member identified by id is currently blocked
+// from joining the accociated channel.
+func (c *Channel) isBlocked(id string) bool {
+	c.Lock()
+	defer c.Unlock()
+	until, ok := c.blocked[id]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(c.blocked, id)
+		return false
+	}
+	return true
+}
+
+// isChannelModerator returns true if the user of the provided user record is
+// a moderator of the provided channel. Only group channels have moderators.
+func (m *Manager) isChannelModerator(channel *Channel, ur *userRecord) bool {
+	if ur == nil || channel.config.Group == "" {
+		return false
+	}
+	if m.isClaimModerator(channel, ur) {
+		return true
+	}
+
+	return channel.IsModerator(ur.id)
+}
+
+// isClaimModerator returns true if the user of the provided user record is a
+// moderator of the provided channel by the moderator claim of its auth. Such
+// moderators cannot be revoked or kicked by channel moderators.
+func (m *Manager) isClaimModerator(channel *Channel, ur *userRecord) bool {
+	if ur == nil || ur.auth == nil || channel.config.Group == "" {
+		return false
+	}
+
+	return !ur.auth.IsGuest() && ur.auth.IsModerator(channel.config.Group)
+}
+
+// getChannelModerators returns the sorted IDs of all current members of the
+// provided channel which are moderators.
+func (m *Manager) getChannelModerators(channel *Channel) []string {
+	members, connections := channel.Connections()
+
+	moderators := make([]string, 0)
+	for idx, connection := range connections {
+		ur, _ := connection.Bound().(*userRecord)
+		if ur == nil || ur.id != members[idx] {
+			continue
+		}
+		if m.isChannelModerator(channel, ur) {
+			moderators = append(moderators, ur.id)
+		}
+	}
+	sort.Strings(moderators)
+
+	return moderators
+}
+
+func (m *Manager) processWebRTCModeration(c *connection.Connection, msg *api.RTMTypeWebRTC, ur *userRecord) error {
+	// Connection must have a user.
+	if ur == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection has no user", msg.ID)
+	}
+	// Channel and target must not be empty.
+	if msg.Channel == "" || msg.Target == "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel or target is empty", msg.ID)
+	}
+	// Target cannot be the same as source.
+	if msg.Target == ur.id {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "target same as source", msg.ID)
+	}
+	// Source must always be empty when received here.
+	if msg.Source != "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "source must be empty", msg.ID)
+	}
+
+	// Get channel, only group channels support moderation.
+	record, ok := m.channels.Get(msg.Channel)
+	if !ok {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel not found", msg.ID)
+	}
+	channel := record.(*channelRecord).channel
+	if channel.config.Group == "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel has no moderation", msg.ID)
+	}
+
+	// Receiving connection must be in channel and be a moderator.
+	if cc, _ := channel.Get(ur.id); cc != c {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection not in channel", msg.ID)
+	}
+	if !m.isChannelModerator(channel, ur) {
+		return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "not a moderator", msg.ID)
+	}
+
+	// Target must be in channel.
+	targetConnection, _ := channel.Get(msg.Target)
+	if targetConnection == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "target not in channel", msg.ID)
+	}
+
+	// Check extra data.
+	extra := &api.RTMDataWebRTCModeration{}
+	if msg.Data != nil {
+		if err := json.Unmarshal(msg.Data, extra); err != nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "moderation data parse error", msg.ID)
+		}
+	}
+
+	// Moderators by claim keep their role and cannot be kicked.
+	tur, _ := targetConnection.Bound().(*userRecord)
+	if m.isClaimModerator(channel, tur) {
+		switch {
+		case msg.Subtype == api.RTMSubtypeNameWebRTCKick:
+			return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "target cannot be kicked", msg.ID)
+		case msg.Subtype == api.RTMSubtypeNameWebRTCModerator && !extra.Moderator:
+			return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "target moderator role cannot be revoked", msg.ID)
+		}
+	}
+
+	// Create profiles.
+	profile := &api.RTMDataProfile{}
+	moderatorName := "A moderator"
+	if ur.auth != nil && ur.auth.Name() != "" {
+		moderatorName = ur.auth.Name()
+		profile.Name = moderatorName
+	}
+	targetName := "Unknown user"
+	if tur != nil && tur.auth != nil && tur.auth.Name() != "" {
+		targetName = tur.auth.Name()
+	}
+
+	var text string
+	var notify bool
+	switch msg.Subtype {
+	case api.RTMSubtypeNameWebRTCKick:
+		duration := channelKickBlockDuration
+		if extra.Duration > 0 {
+			duration = time.Duration(extra.Duration) * time.Second
+			if duration > maximalChannelKickBlockDuration {
+				duration = maximalChannelKickBlockDuration
+			}
+		}
+		extra.Duration = int64(duration / time.Second)
+		channel.block(msg.Target, time.Now().Add(duration))
+		text = targetName + " was removed from the meeting by " + moderatorName + "."
+		notify = true
+
+	case api.RTMSubtypeNameWebRTCMute:
+		switch extra.Kind {
+		case "", "audio", "video":
+		default:
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "invalid mute kind", msg.ID)
+		}
+		text = moderatorName + " asked " + targetName + " to mute."
+		notify = true
+
+	case api.RTMSubtypeNameWebRTCModerator:
+		channel.SetModerator(msg.Target, extra.Moderator)
+		if extra.Moderator {
+			text = moderatorName + " made " + targetName + " a moderator."
+		} else {
+			text = moderatorName + " revoked the moderator role of " + targetName + "."
+		}
+	}
+
+	data, err := json.MarshalIndent(extra, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// Notify target.
+	if notify {
+		err = targetConnection.Send(&api.RTMTypeWebRTC{
+			RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+				Type:    api.RTMTypeNameWebRTC,
+				Subtype: msg.Subtype,
+			},
+			Source:  ur.id,
+			Target:  msg.Target,
+			Channel: channel.id,
+			Group:   channel.config.Group,
+			Profile: profile,
+			Version: currentWebRTCPayloadVersion,
+			Data:    data,
+		})
+		if err != nil {
+			targetConnection.Logger().WithError(err).WithField("channel", channel.id).Debugln("failed to send moderation to target")
+		}
+	}
+
+	switch msg.Subtype {
+	case api.RTMSubtypeNameWebRTCKick:
+		channel.Remove(msg.Target)
+	case api.RTMSubtypeNameWebRTCModerator:
+		// Let everyone know the new moderators.
+		go m.onAfterGroupAddOrRemove(channel, ChannelOpAdd, "")
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"channel": channel.id,
+		"target":  msg.Target,
+		"action":  msg.Subtype,
+	}).Debugln("channel moderation")
+
+	m.emitChannelChatsSystem(channel, ur.id, profile, text, map[string]interface{}{
+		"id":     msg.Subtype,
+		"target": msg.Target,
+	})
+
+	return c.Send(&api.RTMTypeWebRTCReply{
+		RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: msg.Subtype,
+			ReplyTo: msg.ID,
+		},
+		Channel: channel.id,
+		Data:    data,
+		Version: currentWebRTCPayloadVersion,
+	})
+}
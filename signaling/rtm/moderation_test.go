@@ -0,0 +1,149 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+func addTestChannelMember(t *testing.T, channel *Channel, id string, auth *api.AdminAuthToken) *connection.Connection {
+	c := newTestConnection(t, id)
+	c.Bind(&userRecord{
+		id:   id,
+		auth: auth,
+	})
+	if err := channel.Add(id, c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestChannelModeratorPassOn(t *testing.T) {
+	guest := &api.AdminAuthToken{
+		GroupRestriction: map[string]bool{"group1": true},
+	}
+
+	tests := []struct {
+		name      string
+		members   []string
+		guests    map[string]bool
+		remove    string
+		moderator string
+	}{
+		{"to lowest member", []string{"user1", "user3", "user2"}, nil, "user1", "user2"},
+		{"not to guests", []string{"user1", "user2", "user3"}, map[string]bool{"user2": true}, "user1", "user3"},
+		{"only guests", []string{"user1", "user2"}, map[string]bool{"user2": true}, "user1", ""},
+		{"not when other member leaves", []string{"user1", "user2", "user3"}, nil, "user2", "user1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			m := newTestManager(ctx)
+			channel := CreateKnownChannel("@group1", m, &ChannelConfig{
+				Group: "group1",
+			})
+			for _, id := range test.members {
+				auth := &api.AdminAuthToken{}
+				if test.guests[id] {
+					auth = guest
+				}
+				addTestChannelMember(t, channel, id, auth)
+			}
+			channel.setModeratorIfNone(test.members[0])
+
+			if err := channel.Remove(test.remove); err != nil {
+				t.Fatal(err)
+			}
+			if channel.IsModerator(test.remove) && test.remove != test.moderator {
+				t.Errorf("expected %v to be no longer moderator", test.remove)
+			}
+			for _, id := range test.members {
+				if moderator := channel.IsModerator(id); moderator != (id == test.moderator) {
+					t.Errorf("expected %v moderator %v, got %v", id, id == test.moderator, moderator)
+				}
+			}
+		})
+	}
+}
+
+func TestWebRTCModerationOfClaimModerators(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    *api.AdminAuthToken
+		subtype string
+		extra   *api.RTMDataWebRTCModeration
+	}{
+		{"kick global moderator", &api.AdminAuthToken{Moderator: true}, api.RTMSubtypeNameWebRTCKick, &api.RTMDataWebRTCModeration{Duration: 86400}},
+		{"kick group moderator", &api.AdminAuthToken{ModeratorGroups: map[string]bool{"group1": true}}, api.RTMSubtypeNameWebRTCKick, &api.RTMDataWebRTCModeration{}},
+		{"revoke global moderator", &api.AdminAuthToken{Moderator: true}, api.RTMSubtypeNameWebRTCModerator, &api.RTMDataWebRTCModeration{}},
+		{"revoke group moderator", &api.AdminAuthToken{ModeratorGroups: map[string]bool{"group1": true}}, api.RTMSubtypeNameWebRTCModerator, &api.RTMDataWebRTCModeration{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			m := newTestManager(ctx)
+			channel := CreateKnownChannel("@group1", m, &ChannelConfig{
+				Group: "group1",
+			})
+			m.channels.Set(channel.id, &channelRecord{channel: channel})
+
+			c := addTestChannelMember(t, channel, "user1", &api.AdminAuthToken{})
+			tc := addTestChannelMember(t, channel, "user2", test.auth)
+			channel.SetModerator("user1", true)
+
+			data, err := json.Marshal(test.extra)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.processWebRTCModeration(c, &api.RTMTypeWebRTC{
+				RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+					ID:      1,
+					Type:    api.RTMTypeNameWebRTC,
+					Subtype: test.subtype,
+				},
+				Channel: channel.id,
+				Target:  "user2",
+				Data:    data,
+			}, c.Bound().(*userRecord))
+
+			if rtmErr, ok := err.(*api.RTMTypeError); !ok || rtmErr.ErrorData.Code != api.RTMErrorIDAccessRestricted {
+				t.Errorf("expected access restricted error, got %v", err)
+			}
+			if cc, _ := channel.Get("user2"); cc != tc {
+				t.Errorf("expected target to stay in channel")
+			}
+			if channel.isBlocked("user2") {
+				t.Errorf("expected target not to be blocked")
+			}
+			if !m.isChannelModerator(channel, tc.Bound().(*userRecord)) {
+				t.Errorf("expected target to stay moderator")
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"stash.kopano.io/kgol/rndm"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+const (
+	maxServerNotices             = 20
+	maxServerNoticeMessageLength = 1000
+)
+
+// AddServerNotice adds the provided server notice and sends the updated server
+// status to all its recipients.
+func (m *Manager) AddServerNotice(notice *api.AdminServerNotice) error {
+	if notice.ServerNotice == nil || notice.Message == "" {
+		return errors.New("message is empty")
+	}
+	if len(notice.Message) > maxServerNoticeMessageLength {
+		return errors.New("message too long")
+	}
+	for _, message := range notice.Localized {
+		if len(message) > maxServerNoticeMessageLength {
+			return errors.New("localized message too long")
+		}
+	}
+	switch notice.Severity {
+	case "":
+		notice.Severity = api.ServerNoticeSeverityInfo
+	case api.ServerNoticeSeverityInfo, api.ServerNoticeSeverityWarning, api.ServerNoticeSeverityCritical:
+	default:
+		return fmt.Errorf("invalid severity: %v", notice.Severity)
+	}
+	now := time.Now()
+	if notice.Expires == 0 {
+		notice.Expires = notice.Maintenance
+	}
+	if isServerNoticeExpired(notice, now) {
+		return errors.New("notice already expired")
+	}
+	notice.ID = rndm.GenerateRandomString(16)
+	notice.Created = now.Unix()
+
+	m.purgeExpiredServerNotices()
+
+	m.noticesMutex.Lock()
+	if len(m.notices) >= maxServerNotices {
+		m.noticesMutex.Unlock()
+		return errors.New("too many notices")
+	}
+	m.notices = append(m.notices, notice)
+	m.noticesMutex.Unlock()
+
+	m.logger.WithFields(logrus.Fields{
+		"id":       notice.ID,
+		"severity": notice.Severity,
+		"users":    len(notice.Users),
+		"groups":   len(notice.Groups),
+	}).Infoln("server notice added")
+
+	return m.sendServerStatus(func(userID string) bool {
+		return m.isServerNoticeRecipient(notice, userID)
+	})
+}
+
+// RemoveServerNotice removes the server notice with the provided ID and sends
+// the updated server status to all its recipients. It returns false if there
+// is no such notice.
+func (m *Manager) RemoveServerNotice(id string) bool {
+	var notice *api.AdminServerNotice
+
+	m.noticesMutex.Lock()
+	for idx, n := range m.notices {
+		if n.ID == id {
+			notice = n
+			m.notices = append(m.notices[:idx:idx], m.notices[idx+1:]...)
+			break
+		}
+	}
+	m.noticesMutex.Unlock()
+
+	if notice == nil {
+		return false
+	}
+
+	m.logger.WithField("id", id).Infoln("server notice removed")
+	m.sendServerStatus(func(userID string) bool {
+		return m.isServerNoticeRecipient(notice, userID)
+	})
+	return true
+}
+
+// purgeExpiredServerNotices removes all expired server notices and sends the
+// updated server status to their recipients.
+func (m *Manager) purgeExpiredServerNotices() {
+	now := time.Now()
+	var expired []*api.AdminServerNotice
+
+	m.noticesMutex.Lock()
+	notices := m.notices[:0]
+	for _, notice := range m.notices {
+		if isServerNoticeExpired(notice, now) {
+			expired = append(expired, notice)
+		} else {
+			notices = append(notices, notice)
+		}
+	}
+	for idx := len(notices); idx < len(m.notices); idx++ {
+		m.notices[idx] = nil
+	}
+	m.notices = notices
+	m.noticesMutex.Unlock()
+
+	for _, notice := range expired {
+		m.logger.WithField("id", notice.ID).Infoln("server notice expired")
+		m.sendServerStatus(func(userID string) bool {
+			return m.isServerNoticeRecipient(notice, userID)
+		})
+	}
+}
+
+// isServerNoticeExpired returns true if the provided notice has expired at
+// the provided time.
+func isServerNoticeExpired(notice *api.AdminServerNotice, now time.Time) bool {
+	return notice.Expires > 0 && now.Unix() >= notice.Expires
+}
+
+// getServerNotices returns all current server notices which have not expired.
+func (m *Manager) getServerNotices() []*api.AdminServerNotice {
+	now := time.Now()
+
+	m.noticesMutex.RLock()
+	notices := make([]*api.AdminServerNotice, 0, len(m.notices))
+	for _, notice := range m.notices {
+		if !isServerNoticeExpired(notice, now) {
+			notices = append(notices, notice)
+		}
+	}
+	m.noticesMutex.RUnlock()
+
+	return notices
+}
+
+// isServerNoticeRecipient returns true if the user with the provided ID is a
+// recipient of the provided notice. Members of named group channels of the
+// notice's groups are recipients.
+func (m *Manager) isServerNoticeRecipient(notice *api.AdminServerNotice, userID string) bool {
+	if len(notice.Users) == 0 && len(notice.Groups) == 0 {
+		return true
+	}
+	for _, user := range notice.Users {
+		if user == userID {
+			return true
+		}
+	}
+	for _, group := range notice.Groups {
+		channelID, _ := CreateNamedGroupChannelID(group, m)
+		if record, ok := m.channels.Get(channelID); ok {
+			if cc, _ := record.(*channelRecord).channel.Get(userID); cc != nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// getServerStatusForUser returns the server status together with the server
+// notices for the user with the provided ID.
+func (m *Manager) getServerStatusForUser(userID string) *api.ServerStatus {
+	serverStatus := m.getServerStatus()
+
+	notices := m.getServerNotices()
+	if len(notices) == 0 {
+		return serverStatus
+	}
+
+	result := &api.ServerStatus{}
+	if serverStatus != nil {
+		*result = *serverStatus
+	}
+	result.Notices = make([]*api.ServerNotice, 0, len(notices))
+	for _, notice := range notices {
+		if m.isServerNoticeRecipient(notice, userID) {
+			result.Notices = append(result.Notices, notice.ServerNotice)
+		}
+	}
+
+	return result
+}
+
+// sendGroupServerNotices sends the server status to the provided connection
+// of the user with the provided ID, if there are server notices for the
+// provided group. This is used when the user joins the group, since notices
+// for groups are only sent to their members when added.
+func (m *Manager) sendGroupServerNotices(c *connection.Connection, userID string, group string) {
+	found := false
+	for _, notice := range m.getServerNotices() {
+		for _, g := range notice.Groups {
+			if g == group {
+				found = true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	if !found {
+		return
+	}
+
+	err := c.Send(&api.RTMTypeHello{
+		Type: api.RTMTypeNameServer,
+
+		ServerStatus: m.getServerStatusForUser(userID),
+	})
+	if err != nil {
+		c.Logger().WithError(err).Debugln("failed to send group server notices")
+	}
+}
+
+// sendServerStatus sends the server status to all connections of users for
+// which the provided match function returns true, or to all connections if
+// match is nil.
+func (m *Manager) sendServerStatus(match func(userID string) bool) error {
+	payloads := make(map[string][]byte)
+	for entry := range m.connections.IterBuffered() {
+		c := entry.Val.(*connection.Connection)
+		userID := ""
+		if ur, _ := c.Bound().(*userRecord); ur != nil {
+			userID = ur.id
+		}
+		if match != nil && !match(userID) {
+			continue
+		}
+
+		payload, ok := payloads[userID]
+		if !ok {
+			// Prepare server hello message.
+			msg := &api.RTMTypeHello{
+				Type: api.RTMTypeNameServer,
+
+				ServerStatus: m.getServerStatusForUser(userID),
+			}
+			var err error
+			payload, err = json.MarshalIndent(msg, "", "\t")
+			if err != nil {
+				m.logger.WithError(err).Debugln("websocket server status hello send error")
+				return err
+			}
+			payloads[userID] = payload
+		}
+
+		if err := c.RawSend(payload); err != nil {
+			c.Logger().WithError(err).Errorln("failed to send server status to connection")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+)
+
+func TestIsServerNoticeExpired(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		expires int64
+		expired bool
+	}{
+		{0, false},
+		{now.Add(time.Minute).Unix(), false},
+		{now.Unix(), true},
+		{now.Add(-time.Minute).Unix(), true},
+	}
+
+	for idx, test := range tests {
+		notice := &api.AdminServerNotice{
+			ServerNotice: &api.ServerNotice{},
+			Expires:      test.expires,
+		}
+		if expired := isServerNoticeExpired(notice, now); expired != test.expired {
+			t.Errorf("%d: expired %v does not match expected %v", idx, expired, test.expired)
+		}
+	}
+}
+
+func TestAddServerNoticeExpiry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := newTestManager(ctx)
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		maintenance int64
+		expires     int64
+		success     bool
+	}{
+		{"no expiry", 0, 0, true},
+		{"future expiry", 0, now.Add(time.Hour).Unix(), true},
+		{"past expiry", 0, now.Add(-time.Hour).Unix(), false},
+		{"future maintenance", now.Add(time.Hour).Unix(), 0, true},
+		{"past maintenance", now.Add(-time.Hour).Unix(), 0, false},
+	}
+
+	for _, test := range tests {
+		notice := &api.AdminServerNotice{
+			ServerNotice: &api.ServerNotice{
+				Message:     test.name,
+				Maintenance: test.maintenance,
+			},
+			Expires: test.expires,
+		}
+		err := m.AddServerNotice(notice)
+		if (err == nil) != test.success {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+		}
+		if err == nil && test.maintenance > 0 && notice.Expires != test.maintenance {
+			t.Errorf("%s: expires %v does not default to maintenance %v", test.name, notice.Expires, test.maintenance)
+		}
+	}
+
+	// Expired notices no longer count against the limit.
+	for len(m.getServerNotices()) < maxServerNotices {
+		if err := m.AddServerNotice(&api.AdminServerNotice{
+			ServerNotice: &api.ServerNotice{Message: "fill"},
+			Expires:      now.Add(time.Hour).Unix(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.AddServerNotice(&api.AdminServerNotice{
+		ServerNotice: &api.ServerNotice{Message: "full"},
+	}); err == nil {
+		t.Errorf("expected error when notices are full")
+	}
+	m.noticesMutex.Lock()
+	for _, notice := range m.notices {
+		notice.Expires = now.Add(-time.Second).Unix()
+	}
+	m.noticesMutex.Unlock()
+	if notices := m.getServerNotices(); len(notices) != 0 {
+		t.Errorf("expected no notices after expiry, got %d", len(notices))
+	}
+	if err := m.AddServerNotice(&api.AdminServerNotice{
+		ServerNotice: &api.ServerNotice{Message: "after expiry"},
+	}); err != nil {
+		t.Errorf("expected success after expiry, got %v", err)
+	}
+	m.noticesMutex.RLock()
+	count := len(m.notices)
+	m.noticesMutex.RUnlock()
+	if count != 1 {
+		t.Errorf("expected 1 notice after purge, got %d", count)
+	}
+}
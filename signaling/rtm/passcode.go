@@ -0,0 +1,219 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"stash.kopano.io/kgol/rndm"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+const (
+	maxGroupPasscodeLength  = 64
+	groupPasscodeSaltLength = 16
+)
+
+// groupPasscodeAttemptRule limits wrong passcode attempts per user and group.
+var groupPasscodeAttemptRule = &RateLimitRule{
+	Rate:  1.0 / 30,
+	Burst: 5,
+}
+
+var groupPasscodeHashKey []byte
+
+func init() {
+	groupPasscodeHashKey = rndm.GenerateRandomBytes(32)
+}
+
+func computeGroupPasscodeHash(salt []byte, passcode string) []byte {
+	h := hmac.New(sha256.New, groupPasscodeHashKey)
+	h.Write(salt)
+	h.Write([]byte(passcode))
+
+	return h.Sum(nil)
+}
+
+// groupPasscode is the hashed passcode of a group.
+type groupPasscode struct {
+	sync.Mutex
+
+	salt []byte
+	hash []byte
+
+	// Passcodes set by moderators are removed together with the group channel,
+	// passcodes set through the admin API remain until removed.
+	moderator bool
+
+	failures map[string]*tokenBucket
+}
+
+func newGroupPasscode(passcode string, moderator bool) *groupPasscode {
+	salt := rndm.GenerateRandomBytes(groupPasscodeSaltLength)
+	return &groupPasscode{
+		salt: salt,
+		hash: computeGroupPasscodeHash(salt, passcode),
+
+		moderator: moderator,
+
+		failures: make(map[string]*tokenBucket),
+	}
+}
+
+var (
+	errGroupPasscodeInvalid     = errors.New("invalid passcode")
+	errGroupPasscodeRateLimited = errors.New("too many wrong passcode attempts")
+)
+
+// check compares the provided passcode with the accociated passcode. Wrong
+// attempts are counted for the provided user id.
+func (gp *groupPasscode) check(id string, passcode string) error {
+	gp.Lock()
+	defer gp.Unlock()
+
+	now := time.Now()
+	bucket, ok := gp.failures[id]
+	if ok && !bucket.refill(groupPasscodeAttemptRule, now) {
+		return errGroupPasscodeRateLimited
+	}
+	if hmac.Equal(gp.hash, computeGroupPasscodeHash(gp.salt, passcode)) {
+		delete(gp.failures, id)
+		return nil
+	}
+
+	if !ok {
+		bucket = &tokenBucket{}
+		gp.failures[id] = bucket
+	}
+	bucket.take(groupPasscodeAttemptRule, now)
+	return errGroupPasscodeInvalid
+}
+
+// SetGroupPasscode sets the passcode of the group with the provided id. An
+// empty passcode removes the passcode of the group.
+func (m *Manager) SetGroupPasscode(group string, passcode string) error {
+	return m.setGroupPasscode(group, passcode, false)
+}
+
+func (m *Manager) setGroupPasscode(group string, passcode string, moderator bool) error {
+	if group == "" {
+		return errors.New("group is empty")
+	}
+	if len(passcode) > maxGroupPasscodeLength {
+		return errors.New("passcode too long")
+	}
+
+	if passcode == "" {
+		m.groupPasscodes.Remove(group)
+	} else {
+		m.groupPasscodes.Set(group, newGroupPasscode(passcode, moderator))
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"group":     group,
+		"enabled":   passcode != "",
+		"moderator": moderator,
+	}).Debugln("group passcode set")
+	return nil
+}
+
+// removeModeratorGroupPasscode removes the passcode of the group with the
+// provided id, if it was set by a moderator.
+func (m *Manager) removeModeratorGroupPasscode(group string) {
+	m.groupPasscodes.RemoveCb(group, func(key string, v interface{}, exists bool) bool {
+		return exists && v.(*groupPasscode).moderator
+	})
+}
+
+// checkGroupPasscode validates the passcode sent with the provided group
+// message, if the group has a passcode.
+func (m *Manager) checkGroupPasscode(msg *api.RTMTypeWebRTC, ur *userRecord) error {
+	record, ok := m.groupPasscodes.Get(msg.Group)
+	if !ok {
+		return nil
+	}
+
+	extra := &api.RTMDataWebRTCPasscode{}
+	if msg.Data != nil {
+		// Ignore errors, data without passcode is handled below.
+		json.Unmarshal(msg.Data, extra)
+	}
+	if extra.Passcode == "" {
+		return api.NewRTMTypeError(api.RTMErrorIDPasscodeRequired, "passcode required", msg.ID)
+	}
+
+	switch err := record.(*groupPasscode).check(ur.id, extra.Passcode); err {
+	case nil:
+		return nil
+	case errGroupPasscodeRateLimited:
+		return api.NewRTMTypeError(api.RTMErrorIDRateLimited, err.Error(), msg.ID)
+	default:
+		m.logger.WithFields(logrus.Fields{
+			"group": msg.Group,
+			"user":  ur.id,
+		}).Debugln("group passcode check failed")
+		return api.NewRTMTypeError(api.RTMErrorIDPasscodeInvalid, err.Error(), msg.ID)
+	}
+}
+
+func (m *Manager) processWebRTCPasscode(c *connection.Connection, msg *api.RTMTypeWebRTC, ur *userRecord) error {
+	// Connection must have a user.
+	if ur == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection has no user", msg.ID)
+	}
+	// Channel and data must not be empty.
+	if msg.Channel == "" || msg.Data == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel or data is empty", msg.ID)
+	}
+
+	// Get channel, only group channels have passcodes.
+	record, ok := m.channels.Get(msg.Channel)
+	if !ok {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel not found", msg.ID)
+	}
+	channel := record.(*channelRecord).channel
+	if channel.config.Group == "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel has no passcode", msg.ID)
+	}
+
+	// Receiving connection must be in channel and be a moderator.
+	if cc, _ := channel.Get(ur.id); cc != c {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection not in channel", msg.ID)
+	}
+	if !m.isChannelModerator(channel, ur) {
+		return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "not a moderator", msg.ID)
+	}
+
+	extra := &api.RTMDataWebRTCPasscode{}
+	if err := json.Unmarshal(msg.Data, extra); err != nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "passcode data parse error", msg.ID)
+	}
+	if err := m.setGroupPasscode(channel.config.Group, extra.Passcode, true); err != nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
+	}
+
+	return nil
+}
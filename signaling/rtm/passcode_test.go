@@ -0,0 +1,96 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"testing"
+)
+
+func TestGroupPasscodeCheck(t *testing.T) {
+	gp := newGroupPasscode("secret", false)
+
+	tests := []struct {
+		description string
+		id          string
+		passcode    string
+		repeat      int
+		expected    error
+	}{
+		{
+			description: "correct passcode",
+			id:          "user1",
+			passcode:    "secret",
+			repeat:      1,
+		},
+		{
+			description: "wrong passcode",
+			id:          "user1",
+			passcode:    "wrong",
+			repeat:      4,
+			expected:    errGroupPasscodeInvalid,
+		},
+		{
+			description: "correct passcode resets failures",
+			id:          "user1",
+			passcode:    "secret",
+			repeat:      1,
+		},
+		{
+			description: "wrong passcode up to burst",
+			id:          "user1",
+			passcode:    "wrong",
+			repeat:      groupPasscodeAttemptRule.Burst,
+			expected:    errGroupPasscodeInvalid,
+		},
+		{
+			description: "wrong passcode after burst",
+			id:          "user1",
+			passcode:    "wrong",
+			repeat:      1,
+			expected:    errGroupPasscodeRateLimited,
+		},
+		{
+			description: "correct passcode after burst",
+			id:          "user1",
+			passcode:    "secret",
+			repeat:      1,
+			expected:    errGroupPasscodeRateLimited,
+		},
+		{
+			description: "correct passcode of other user",
+			id:          "user2",
+			passcode:    "secret",
+			repeat:      1,
+		},
+		{
+			description: "empty passcode",
+			id:          "user2",
+			passcode:    "",
+			repeat:      1,
+			expected:    errGroupPasscodeInvalid,
+		},
+	}
+
+	for _, tc := range tests {
+		for i := 0; i < tc.repeat; i++ {
+			if err := gp.check(tc.id, tc.passcode); err != tc.expected {
+				t.Errorf("%s attempt %d returned wrong result: got %v, want %v", tc.description, i+1, err, tc.expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,341 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// minimalPresencePayloadVersion defines the presence payload minimal
+// compatibility level of the servers presence payload data as received by
+// clients.
+const minimalPresencePayloadVersion uint64 = 0
+
+// currentPresencePayloadVersion defines the presence payload version sent with
+// payloads generated by the server.
+const currentPresencePayloadVersion uint64 = 20210801
+
+// maximalPresenceSubscriptions is the maximum number of users a single
+// connection can subscribe to.
+const maximalPresenceSubscriptions = 1000
+
+// maximalPresenceTextSize is the maximum number of characters which is
+// accepted for presence status texts.
+const maximalPresenceTextSize = 256
+
+// presenceSubscribers holds all connections which are subscribed to the
+// presence of a user.
+type presenceSubscribers struct {
+	sync.RWMutex
+	connections map[*connection.Connection]bool
+}
+
+// presenceSubscriptions holds all users a connection is subscribed to.
+type presenceSubscriptions struct {
+	sync.Mutex
+	users map[string]bool
+}
+
+func (m *Manager) onPresence(c *connection.Connection, msg *api.RTMTypePresence) error {
+	if msg.Version < minimalPresencePayloadVersion {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "outdated presence payload version", msg.ID)
+	}
+
+	// Fech user record for connection.
+	bound := c.Bound()
+	ur, _ := bound.(*userRecord)
+	// Connection must have a user.
+	if ur == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection has no user", msg.ID)
+	}
+	// Guests have no presence.
+	if ur.auth != nil && ur.auth.IsGuest() {
+		return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "presence is not available for guests", msg.ID)
+	}
+
+	var reply interface{}
+	switch msg.Subtype {
+	case api.RTMSubtypeNamePresenceSubscribe:
+		var extra *api.RTMDataPresenceSubscribe
+		if err := json.Unmarshal(msg.Data, &extra); err != nil || extra == nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "subscribe data parse error", msg.ID)
+		}
+		if !m.subscribePresence(c, extra.Users) {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "presence subscription limit exceeded", msg.ID)
+		}
+
+		// Reply with current presence of all requested users.
+		update := &api.RTMDataPresenceUpdate{
+			Users: make([]*api.RTMDataUserPresence, 0, len(extra.Users)),
+		}
+		for _, userID := range extra.Users {
+			update.Users = append(update.Users, m.getUserPresence(userID))
+		}
+		reply = update
+
+	case api.RTMSubtypeNamePresenceUnsubscribe:
+		var extra *api.RTMDataPresenceSubscribe
+		if err := json.Unmarshal(msg.Data, &extra); err != nil || extra == nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "unsubscribe data parse error", msg.ID)
+		}
+		m.unsubscribePresence(c, extra.Users)
+
+	case api.RTMSubtypeNamePresenceSet:
+		var extra *api.RTMDataUserPresence
+		if err := json.Unmarshal(msg.Data, &extra); err != nil || extra == nil {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "presence data parse error", msg.ID)
+		}
+		// Presence user must be empty or self.
+		if extra.User != "" && extra.User != ur.id {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "presence user must be empty", msg.ID)
+		}
+		switch extra.Status {
+		case api.RTMPresenceStatusOnline, api.RTMPresenceStatusAway, api.RTMPresenceStatusBusy:
+			// Ok.
+		default:
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "invalid presence status", msg.ID)
+		}
+		extra.Text = strings.TrimSpace(extra.Text)
+		if len(extra.Text) > maximalPresenceTextSize {
+			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "presence text size limit exceeded", msg.ID)
+		}
+
+		ur.Lock()
+		changed := ur.status != extra.Status || ur.statusText != extra.Text
+		if changed {
+			ur.status = extra.Status
+			ur.statusText = extra.Text
+			ur.statusWhen = time.Now()
+		}
+		ur.Unlock()
+
+		reply = &api.RTMDataPresenceUpdate{
+			Users: []*api.RTMDataUserPresence{m.getUserPresence(ur.id)},
+		}
+		if changed {
+			m.emitPresence(ur.id, c)
+		}
+
+	default:
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "unknown subtype", msg.ID)
+	}
+
+	var data json.RawMessage
+	if reply != nil {
+		var err error
+		data, err = json.MarshalIndent(reply, "", "\t")
+		if err != nil {
+			return err
+		}
+	}
+	return c.Send(&api.RTMTypePresenceReply{
+		RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
+			Type:    api.RTMTypeNamePresence,
+			Subtype: msg.Subtype,
+			ReplyTo: msg.ID,
+		},
+		Version: currentPresencePayloadVersion,
+		Data:    data,
+	})
+}
+
+// getUserPresence returns the current presence of the user identified by the
+// provided user ID.
+func (m *Manager) getUserPresence(userID string) *api.RTMDataUserPresence {
+	presence := &api.RTMDataUserPresence{
+		User:   userID,
+		Status: api.RTMPresenceStatusOffline,
+	}
+
+	record, ok := m.users.Get(userID)
+	if !ok {
+		return presence
+	}
+	ur := record.(*userRecord)
+
+	ur.RLock()
+	defer ur.RUnlock()
+
+	if len(ur.connections) == 0 {
+		if !ur.exit.IsZero() {
+			presence.TS = ur.exit.Unix()
+		}
+		return presence
+	}
+
+	presence.Status = ur.status
+	presence.Text = ur.statusText
+	if presence.Status == "" {
+		presence.Status = api.RTMPresenceStatusOnline
+	}
+	if ur.statusWhen.After(ur.when) {
+		presence.TS = ur.statusWhen.Unix()
+	} else {
+		presence.TS = ur.when.Unix()
+	}
+	return presence
+}
+
+// subscribePresence adds presence subscriptions for the provided user IDs to
+// the provided connection. It returns false, if this would exceed the maximum
+// number of subscriptions per connection.
+func (m *Manager) subscribePresence(c *connection.Connection, userIDs []string) bool {
+	record := m.presenceSubscriptions.Upsert(c.ID(), nil, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
+		if exist {
+			return valueInMap
+		}
+		return &presenceSubscriptions{
+			users: make(map[string]bool),
+		}
+	})
+	subscriptions := record.(*presenceSubscriptions)
+
+	subscriptions.Lock()
+	defer subscriptions.Unlock()
+
+	added := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if userID == "" || subscriptions.users[userID] {
+			continue
+		}
+		added = append(added, userID)
+	}
+	if len(subscriptions.users)+len(added) > maximalPresenceSubscriptions {
+		return false
+	}
+
+	for _, userID := range added {
+		subscriptions.users[userID] = true
+		record := m.presenceSubscribers.Upsert(userID, nil, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
+			if exist {
+				return valueInMap
+			}
+			return &presenceSubscribers{
+				connections: make(map[*connection.Connection]bool),
+			}
+		})
+		subscribers := record.(*presenceSubscribers)
+		subscribers.Lock()
+		subscribers.connections[c] = true
+		subscribers.Unlock()
+	}
+
+	return true
+}
+
+// unsubscribePresence removes the presence subscriptions for the provided user
+// IDs from the provided connection. If userIDs is nil, all subscriptions of
+// the connection are removed.
+func (m *Manager) unsubscribePresence(c *connection.Connection, userIDs []string) {
+	record, ok := m.presenceSubscriptions.Get(c.ID())
+	if !ok {
+		return
+	}
+	subscriptions := record.(*presenceSubscriptions)
+
+	subscriptions.Lock()
+	defer subscriptions.Unlock()
+
+	if userIDs == nil {
+		userIDs = make([]string, 0, len(subscriptions.users))
+		for userID := range subscriptions.users {
+			userIDs = append(userIDs, userID)
+		}
+		m.presenceSubscriptions.Remove(c.ID())
+	}
+
+	for _, userID := range userIDs {
+		if !subscriptions.users[userID] {
+			continue
+		}
+		delete(subscriptions.users, userID)
+		m.presenceSubscribers.RemoveCb(userID, func(key string, v interface{}, exists bool) bool {
+			if !exists {
+				return false
+			}
+			subscribers := v.(*presenceSubscribers)
+			subscribers.Lock()
+			defer subscribers.Unlock()
+			delete(subscribers.connections, c)
+			// Remove entry, when this was the last subscriber.
+			return len(subscribers.connections) == 0
+		})
+	}
+}
+
+// emitPresence sends the current presence of the user identified by the
+// provided user ID to all subscribers and to all other connections of that
+// user, except the provided source connection.
+func (m *Manager) emitPresence(userID string, source *connection.Connection) {
+	presence := m.getUserPresence(userID)
+
+	message, err := json.MarshalIndent(&api.RTMDataPresenceUpdate{
+		Users: []*api.RTMDataUserPresence{presence},
+	}, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("user_id", userID).Errorln("failed to encode presence update data")
+		return
+	}
+	payload, err := json.MarshalIndent(&api.RTMTypePresence{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNamePresence,
+			Subtype: api.RTMSubtypeNamePresenceUpdate,
+		},
+		Version: currentPresencePayloadVersion,
+		Data:    message,
+	}, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("user_id", userID).Errorln("failed to encode presence update")
+		return
+	}
+
+	connections := make(map[*connection.Connection]bool)
+	if record, ok := m.presenceSubscribers.Get(userID); ok {
+		subscribers := record.(*presenceSubscribers)
+		subscribers.RLock()
+		for connection := range subscribers.connections {
+			connections[connection] = true
+		}
+		subscribers.RUnlock()
+	}
+	if record, ok := m.users.Get(userID); ok {
+		ur := record.(*userRecord)
+		ur.RLock()
+		for _, connection := range ur.connections {
+			connections[connection] = true
+		}
+		ur.RUnlock()
+	}
+
+	for connection := range connections {
+		if connection == source {
+			// Skip sending update to source.
+			continue
+		}
+		err = connection.RawSend(payload)
+		if err != nil {
+			connection.Logger().WithError(err).WithField("user_id", userID).Debugln("failed to send presence update to connection")
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// setPayloadVersion sets the payload version of the member identified by the
+// provided id, as sent by its client when joining.
+func (c *Channel) setPayloadVersion(id string, version uint64) {
+	c.Lock()
+	c.versions[id] = version
+	c.Unlock()
+}
+
+// wantsProfiles returns true if the client of the member identified by the
+// provided id supports member profiles.
+func (c *Channel) wantsProfiles(id string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.versions[id] >= profilesWebRTCPayloadVersion
+}
+
+// getUserProfile returns the profile of the user of the provided user record
+// as it is exposed to other users.
+func (m *Manager) getUserProfile(ur *userRecord) *api.RTMDataProfile {
+	profile := &api.RTMDataProfile{}
+	if ur == nil || ur.auth == nil {
+		return profile
+	}
+
+	profile.Name = ur.auth.Name()
+	profile.Guest = ur.auth.IsGuest()
+	for _, claim := range m.profileClaims {
+		if value, ok := ur.auth.Claims[claim]; ok {
+			if profile.Claims == nil {
+				profile.Claims = make(map[string]interface{})
+			}
+			profile.Claims[claim] = value
+		}
+	}
+
+	return profile
+}
+
+// getChannelProfiles returns the profiles of the provided members, mapped by
+// member ID. Members and connections are expected as returned by
+// Channel.Connections.
+func (m *Manager) getChannelProfiles(members []string, connections []*connection.Connection) map[string]*api.RTMDataProfile {
+	profiles := make(map[string]*api.RTMDataProfile, len(members))
+	for idx, id := range members {
+		ur, _ := connections[idx].Bound().(*userRecord)
+		profiles[id] = m.getUserProfile(ur)
+	}
+
+	return profiles
+}
@@ -0,0 +1,344 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// Rate limit scopes, defining whose messages share a token bucket.
+const (
+	RateLimitScopeConnection = "connection"
+	RateLimitScopeUser       = "user"
+)
+
+// rateLimitViolationWindow is the duration in which rate limit violations of a
+// connection are counted against the disconnect threshold.
+const rateLimitViolationWindow = time.Duration(1) * time.Minute
+
+// Rate limited users are counted per cleanup interval, at most
+// rateLimitedUsersMax of them. The rateLimitedUsersTop users with the most
+// rate limited messages are logged at the end of each interval.
+const (
+	rateLimitedUsersMax = 1000
+	rateLimitedUsersTop = 10
+)
+
+// A RateLimitRule defines the rate and burst of incoming messages of a type
+// and optional subtype, per connection or per user.
+type RateLimitRule struct {
+	Scope   string
+	Type    string
+	Subtype string
+	Rate    float64
+	Burst   int
+}
+
+// ParseRateLimitRule parses the provided rate limit rule string value. The
+// format is `[SCOPE:]TYPE[/SUBTYPE]=RATE[,BURST]`, where SCOPE is one of
+// `connection` (the default) or `user`, RATE is the number of messages per
+// second and BURST defaults to RATE rounded up.
+func ParseRateLimitRule(value string) (*RateLimitRule, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid rate limit rule: %v", value)
+	}
+
+	rule := &RateLimitRule{
+		Scope: RateLimitScopeConnection,
+	}
+	selector := parts[0]
+	if idx := strings.Index(selector, ":"); idx >= 0 {
+		rule.Scope = selector[:idx]
+		selector = selector[idx+1:]
+	}
+	switch rule.Scope {
+	case RateLimitScopeConnection, RateLimitScopeUser:
+	default:
+		return nil, fmt.Errorf("invalid rate limit rule scope: %v", value)
+	}
+	types := strings.SplitN(selector, "/", 2)
+	rule.Type = types[0]
+	if len(types) > 1 {
+		rule.Subtype = types[1]
+	}
+	if rule.Type == "" {
+		return nil, fmt.Errorf("invalid rate limit rule type: %v", value)
+	}
+
+	limits := strings.SplitN(parts[1], ",", 2)
+	rate, err := strconv.ParseFloat(limits[0], 64)
+	if err != nil || rate <= 0 {
+		return nil, fmt.Errorf("invalid rate limit rule rate: %v", value)
+	}
+	rule.Rate = rate
+	rule.Burst = int(rate)
+	if float64(rule.Burst) < rate {
+		rule.Burst++
+	}
+	if len(limits) > 1 {
+		burst, err := strconv.Atoi(limits[1])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid rate limit rule burst: %v", value)
+		}
+		rule.Burst = burst
+	}
+
+	return rule, nil
+}
+
+// Matches returns true if the accociated rule applies to messages with the
+// provided type and subtype.
+func (rule *RateLimitRule) Matches(typ string, subtype string) bool {
+	return rule.Type == typ && (rule.Subtype == "" || rule.Subtype == subtype)
+}
+
+// tokenBucket implements the token bucket algorithm.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the accociated bucket for the time passed since its last use
+// and takes a token if one is available.
+func (b *tokenBucket) take(rule *RateLimitRule, now time.Time) bool {
+	if !b.refill(rule, now) {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refill refills the accociated bucket for the time passed since its last use
+// and returns true if a token is available.
+func (b *tokenBucket) refill(rule *RateLimitRule, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(rule.Burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rule.Rate
+		if b.tokens > float64(rule.Burst) {
+			b.tokens = float64(rule.Burst)
+		}
+	}
+	b.last = now
+
+	return b.tokens >= 1
+}
+
+// rateLimiter holds the token buckets of a connection or user.
+type rateLimiter struct {
+	sync.Mutex
+	buckets map[*RateLimitRule]*tokenBucket
+
+	violations      int
+	violationsStart time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[*RateLimitRule]*tokenBucket),
+	}
+}
+
+// allow takes a token from the bucket of the provided rule.
+func (limiter *rateLimiter) allow(rule *RateLimitRule, now time.Time) bool {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	bucket, ok := limiter.buckets[rule]
+	if !ok {
+		bucket = &tokenBucket{}
+		limiter.buckets[rule] = bucket
+	}
+	return bucket.take(rule, now)
+}
+
+// violate records a rate limit violation and returns the number of violations
+// in the current violation window.
+func (limiter *rateLimiter) violate(now time.Time) int {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	if now.Sub(limiter.violationsStart) > rateLimitViolationWindow {
+		limiter.violations = 0
+		limiter.violationsStart = now
+	}
+	limiter.violations++
+	return limiter.violations
+}
+
+// rateLimitedUser is the number of rate limited messages of a user.
+type rateLimitedUser struct {
+	id    string
+	count uint64
+}
+
+// countRateLimitedUser counts a rate limited message of the provided user.
+func (m *Manager) countRateLimitedUser(userID string) {
+	m.rateLimitedUsersMutex.Lock()
+	defer m.rateLimitedUsersMutex.Unlock()
+
+	if m.rateLimitedUsers == nil {
+		m.rateLimitedUsers = make(map[string]uint64)
+	}
+	if _, ok := m.rateLimitedUsers[userID]; !ok && len(m.rateLimitedUsers) >= rateLimitedUsersMax {
+		return
+	}
+	m.rateLimitedUsers[userID]++
+}
+
+// popRateLimitedUsers returns up to max users with the most rate limited
+// messages since the last call, most rate limited first.
+func (m *Manager) popRateLimitedUsers(max int) []*rateLimitedUser {
+	m.rateLimitedUsersMutex.Lock()
+	counts := m.rateLimitedUsers
+	m.rateLimitedUsers = nil
+	m.rateLimitedUsersMutex.Unlock()
+
+	users := make([]*rateLimitedUser, 0, len(counts))
+	for id, count := range counts {
+		users = append(users, &rateLimitedUser{id, count})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].count == users[j].count {
+			return users[i].id < users[j].id
+		}
+		return users[i].count > users[j].count
+	})
+	if len(users) > max {
+		users = users[:max]
+	}
+
+	return users
+}
+
+// logRateLimitedUsers logs the users with the most rate limited messages since
+// the last call.
+func (m *Manager) logRateLimitedUsers() {
+	for idx, user := range m.popRateLimitedUsers(rateLimitedUsersTop) {
+		m.logger.WithFields(logrus.Fields{
+			"user_id": user.id,
+			"count":   user.count,
+			"rank":    idx + 1,
+		}).Infoln("rtm user was rate limited")
+	}
+}
+
+// getRateLimiter returns the rate limiter of the provided scope for the
+// provided connection, creating it when it does not exist yet. It returns nil
+// for the user scope if the connection has no user.
+func (m *Manager) getRateLimiter(c *connection.Connection, scope string) *rateLimiter {
+	switch scope {
+	case RateLimitScopeUser:
+		ur, _ := c.Bound().(*userRecord)
+		if ur == nil {
+			return nil
+		}
+		ur.Lock()
+		defer ur.Unlock()
+		if ur.limiter == nil {
+			ur.limiter = newRateLimiter()
+		}
+		return ur.limiter
+
+	default:
+		record := m.rateLimiters.Upsert(c.ID(), nil, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
+			if exist {
+				return valueInMap
+			}
+			return newRateLimiter()
+		})
+		return record.(*rateLimiter)
+	}
+}
+
+// checkRateLimit applies all matching rate limit rules to the provided
+// incoming message of the provided connection. It returns false if the message
+// must not be processed, either with a rate limit error for the client or with
+// nil if the connection was disconnected for exceeding the disconnect
+// threshold.
+func (m *Manager) checkRateLimit(c *connection.Connection, typ string, msg []byte) (bool, error) {
+	if len(m.rateLimitRules) == 0 {
+		return true, nil
+	}
+
+	var envelope *api.RTMTypeSubtypeEnvelope
+	now := time.Now()
+	for _, rule := range m.rateLimitRules {
+		if rule.Type != typ {
+			continue
+		}
+		if envelope == nil {
+			envelope = &api.RTMTypeSubtypeEnvelope{}
+			if err := json.Unmarshal(msg, envelope); err != nil {
+				return false, err
+			}
+		}
+		if !rule.Matches(typ, envelope.Subtype) {
+			continue
+		}
+		limiter := m.getRateLimiter(c, rule.Scope)
+		if limiter == nil || limiter.allow(rule, now) {
+			continue
+		}
+
+		// Rate limited.
+		userID := ""
+		if ur, _ := c.Bound().(*userRecord); ur != nil {
+			userID = ur.id
+		}
+		rateLimited.WithLabelValues(m.id, typ).Inc()
+		if userID != "" {
+			m.countRateLimitedUser(userID)
+		}
+		c.Logger().WithFields(logrus.Fields{
+			"user_id": userID,
+			"type":    typ,
+			"subtype": envelope.Subtype,
+		}).Debugln("websocket rtm message rate limited")
+
+		violations := m.getRateLimiter(c, RateLimitScopeConnection).violate(now)
+		if m.rateLimitDisconnect > 0 && violations >= m.rateLimitDisconnect {
+			c.Logger().WithFields(logrus.Fields{
+				"user_id":    userID,
+				"violations": violations,
+			}).Debugln("websocket rtm disconnect, rate limit exceeded")
+			c.Send(&api.RTMTypeHello{
+				Type:   api.RTMTypeNameGoodbye,
+				Reason: api.RTMGoodbyeReasonRateLimited,
+			})
+			c.Close()
+			return false, nil
+		}
+
+		return false, api.NewRTMTypeError(api.RTMErrorIDRateLimited, "rate limit exceeded", envelope.ID)
+	}
+
+	return true, nil
+}
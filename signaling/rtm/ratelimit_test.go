@@ -0,0 +1,119 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestParseRateLimitRule(t *testing.T) {
+	tests := []struct {
+		value string
+		rule  *RateLimitRule
+	}{
+		{"chats=5", &RateLimitRule{Scope: RateLimitScopeConnection, Type: "chats", Rate: 5, Burst: 5}},
+		{"chats/chats_typing=0.5", &RateLimitRule{Scope: RateLimitScopeConnection, Type: "chats", Subtype: "chats_typing", Rate: 0.5, Burst: 1}},
+		{"user:webrtc=2.5,10", &RateLimitRule{Scope: RateLimitScopeUser, Type: "webrtc", Rate: 2.5, Burst: 10}},
+		{"connection:chats/chats_message=1,3", &RateLimitRule{Scope: RateLimitScopeConnection, Type: "chats", Subtype: "chats_message", Rate: 1, Burst: 3}},
+		{"chats", nil},
+		{"=5", nil},
+		{"channel:chats=5", nil},
+		{"chats=0", nil},
+		{"chats=-1", nil},
+		{"chats=fast", nil},
+		{"chats=5,0", nil},
+		{"chats=5,many", nil},
+	}
+
+	for _, test := range tests {
+		rule, err := ParseRateLimitRule(test.value)
+		if test.rule == nil {
+			if err == nil {
+				t.Errorf("%v: expected error, got %+v", test.value, rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.value, err)
+			continue
+		}
+		if *rule != *test.rule {
+			t.Errorf("%v: expected %+v, got %+v", test.value, test.rule, rule)
+		}
+	}
+}
+
+func TestRateLimitRuleMatches(t *testing.T) {
+	rule := &RateLimitRule{Type: "chats", Subtype: "chats_typing"}
+	any := &RateLimitRule{Type: "chats"}
+
+	tests := []struct {
+		rule    *RateLimitRule
+		typ     string
+		subtype string
+		matches bool
+	}{
+		{rule, "chats", "chats_typing", true},
+		{rule, "chats", "chats_message", false},
+		{rule, "webrtc", "chats_typing", false},
+		{any, "chats", "chats_message", true},
+		{any, "chats", "", true},
+		{any, "webrtc", "", false},
+	}
+
+	for _, test := range tests {
+		if matches := test.rule.Matches(test.typ, test.subtype); matches != test.matches {
+			t.Errorf("%+v %v/%v: expected %v, got %v", test.rule, test.typ, test.subtype, test.matches, matches)
+		}
+	}
+}
+
+func TestPopRateLimitedUsers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := newTestManager(ctx)
+	for idx, count := range []int{3, 1, 5, 2} {
+		for i := 0; i < count; i++ {
+			m.countRateLimitedUser("user" + strconv.Itoa(idx))
+		}
+	}
+	for i := 0; i < rateLimitedUsersMax; i++ {
+		m.countRateLimitedUser("other" + strconv.Itoa(i))
+	}
+	if len(m.rateLimitedUsers) != rateLimitedUsersMax {
+		t.Errorf("expected %d counted users, got %d", rateLimitedUsersMax, len(m.rateLimitedUsers))
+	}
+
+	users := m.popRateLimitedUsers(3)
+	expected := []*rateLimitedUser{{"user2", 5}, {"user0", 3}, {"user3", 2}}
+	if len(users) != len(expected) {
+		t.Fatalf("expected %d users, got %d", len(expected), len(users))
+	}
+	for idx, user := range users {
+		if *user != *expected[idx] {
+			t.Errorf("%d: expected %+v, got %+v", idx, expected[idx], user)
+		}
+	}
+
+	if users = m.popRateLimitedUsers(rateLimitedUsersMax * 2); len(users) != 0 {
+		t.Errorf("expected no users after pop, got %d", len(users))
+	}
+}
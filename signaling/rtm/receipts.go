@@ -0,0 +1,187 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// chatsReceiptsDelay is the duration for which receipt changes of a chats
+// message are collected, before they are sent to its sender in one go.
+const chatsReceiptsDelay = time.Duration(500) * time.Millisecond
+
+// maximalChannelChatsReceipts is the number of most recent chats messages per
+// channel for which receipts are tracked.
+const maximalChannelChatsReceipts = 1000
+
+// maximalChatsReadIDs is the maximum number of message IDs which can be
+// acknowledged as read with a single message.
+const maximalChatsReadIDs = 100
+
+// Receipt states of a chats message recipient, in ascending order.
+const (
+	chatsReceiptQueued = iota
+	chatsReceiptDelivered
+	chatsReceiptRead
+)
+
+// chatsReceipt holds the receipt states of all recipients of a chats message.
+type chatsReceipt struct {
+	sync.Mutex
+	id         string
+	sender     string
+	recipients map[string]int
+	timer      *time.Timer
+}
+
+// addChatsReceipt starts tracking receipts for the chats message with the
+// provided id, sent by sender to the provided recipients. The caller must hold
+// the channel's chats mutex.
+func (c *Channel) addChatsReceipt(id string, sender string, recipients []string) *chatsReceipt {
+	if len(c.receiptsOrder) >= maximalChannelChatsReceipts {
+		delete(c.receipts, c.receiptsOrder[0])
+		c.receiptsOrder = c.receiptsOrder[1:]
+	}
+	receipt := &chatsReceipt{
+		id:         id,
+		sender:     sender,
+		recipients: make(map[string]int, len(recipients)),
+	}
+	for _, recipient := range recipients {
+		receipt.recipients[recipient] = chatsReceiptQueued
+	}
+	c.receipts[id] = receipt
+	c.receiptsOrder = append(c.receiptsOrder, id)
+
+	return receipt
+}
+
+// chatsReceiptWritten returns a written callback, which marks the provided
+// recipient of the provided receipt as delivered.
+func (m *Manager) chatsReceiptWritten(channel *Channel, receipt *chatsReceipt, recipient string) connection.WrittenFunc {
+	return func(c *connection.Connection) {
+		m.updateChatsReceipt(channel, receipt, recipient, chatsReceiptDelivered)
+	}
+}
+
+// updateChatsReceipt raises the receipt state of the provided recipient and
+// schedules sending the aggregated receipts to the sender of the message.
+// States never go back, so updates can be repeated safely.
+func (m *Manager) updateChatsReceipt(channel *Channel, receipt *chatsReceipt, recipient string, state int) {
+	receipt.Lock()
+	defer receipt.Unlock()
+
+	current, ok := receipt.recipients[recipient]
+	if !ok || current >= state {
+		return
+	}
+	receipt.recipients[recipient] = state
+
+	if receipt.timer == nil {
+		receipt.timer = time.AfterFunc(chatsReceiptsDelay, func() {
+			m.sendChatsReceipt(channel, receipt)
+		})
+	}
+}
+
+// sendChatsReceipt sends the aggregated receipts of the provided receipt to
+// the connection of its sender in the provided channel.
+func (m *Manager) sendChatsReceipt(channel *Channel, receipt *chatsReceipt) {
+	receipts := &api.RTMDataChatsReceipts{
+		Delivered: make([]string, 0),
+		Read:      make([]string, 0),
+	}
+
+	receipt.Lock()
+	receipt.timer = nil
+	receipts.Recipients = len(receipt.recipients)
+	for recipient, state := range receipt.recipients {
+		switch state {
+		case chatsReceiptRead:
+			receipts.Read = append(receipts.Read, recipient)
+			fallthrough
+		case chatsReceiptDelivered:
+			receipts.Delivered = append(receipts.Delivered, recipient)
+		}
+	}
+	receipt.Unlock()
+
+	sort.Strings(receipts.Delivered)
+	sort.Strings(receipts.Read)
+
+	kind := api.RTMChatsMessageKindMessageDelivered
+	if len(receipts.Read) == receipts.Recipients {
+		kind = api.RTMChatsMessageKindMessageRead
+	}
+
+	message, err := json.MarshalIndent(&api.RTMDataChatsMessage{
+		ID:   receipt.id,
+		Kind: kind,
+		TS:   time.Now().Unix(),
+		Extra: map[string]interface{}{
+			"receipts": receipts,
+		},
+	}, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode channel chats receipt system message")
+		return
+	}
+
+	// NOTE(longsleep): Receipts go to the current connection of the sender in
+	// the channel, which might not be the one that sent the message.
+	c, _ := channel.Get(receipt.sender)
+	if c == nil {
+		return
+	}
+	err = c.Send(&api.RTMTypeChats{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameChats,
+			Subtype: api.RTMSubtypeNameChatsSystem,
+		},
+		Channel: channel.id,
+		Data:    message,
+		Version: currentChatsPayloadVersion,
+	})
+	if err != nil {
+		c.Logger().WithError(err).WithField("channel", channel.id).Debugln("failed to send channel chats receipt system message to sender")
+	}
+}
+
+// readChatsReceipts marks the chats messages with the provided ids in the
+// provided channel as read by the provided recipient. Unknown ids are ignored.
+func (m *Manager) readChatsReceipts(channel *Channel, recipient string, ids []string) {
+	receipts := make([]*chatsReceipt, 0, len(ids))
+
+	channel.namedMutexLock(channelMutexChats)
+	for _, id := range ids {
+		if receipt, ok := channel.receipts[id]; ok {
+			receipts = append(receipts, receipt)
+		}
+	}
+	channel.namedMutexUnlock(channelMutexChats)
+
+	for _, receipt := range receipts {
+		m.updateChatsReceipt(channel, receipt, recipient, chatsReceiptRead)
+	}
+}
@@ -0,0 +1,325 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+)
+
+// callRing is a pending call invitation of a channel. Calls to multiple
+// targets use the channel as peer of the source until answered.
+type callRing struct {
+	source   string
+	targets  []string
+	multi    bool
+	answered string
+	rejected map[string]bool
+	timer    *time.Timer
+}
+
+// has returns true if the provided target is invited by the associated ring.
+func (ring *callRing) has(target string) bool {
+	for _, t := range ring.targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// peer returns the target of the associated ring as used for hashes with its
+// source. Calls with multiple targets use the channel as peer.
+func (ring *callRing) peer(channel *Channel) string {
+	if ring.multi {
+		return channel.id
+	}
+	return ring.targets[0]
+}
+
+// stop stops the timer of the associated ring.
+func (ring *callRing) stop() {
+	if ring.timer != nil {
+		ring.timer.Stop()
+	}
+}
+
+// SetCallRingTimeout sets the duration after which unanswered calls are hung
+// up. 0 means calls ring until hung up by the caller.
+func (m *Manager) SetCallRingTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return errors.New("invalid call ring timeout")
+	}
+
+	m.callRingTimeout = timeout
+	return nil
+}
+
+// startCallRing starts tracking the call invitation of the provided channel
+// from source to the provided targets. If multi is true, the call was made to
+// multiple targets.
+func (m *Manager) startCallRing(channel *Channel, source string, targets []string, multi bool) {
+	ring := &callRing{
+		source:   source,
+		targets:  targets,
+		multi:    multi,
+		rejected: make(map[string]bool),
+	}
+	channel.Lock()
+	if m.callRingTimeout > 0 {
+		ring.timer = time.AfterFunc(m.callRingTimeout, func() {
+			if channel.takeCallRing(ring) {
+				m.logger.WithFields(logrus.Fields{
+					"channel": channel.id,
+					"source":  ring.source,
+					"targets": ring.targets,
+				}).Debugln("call ring timeout")
+				m.hangupCallRing(channel, ring, api.RTMHangupReasonTimeout, true)
+			}
+		})
+	}
+	channel.ring = ring
+	channel.Unlock()
+}
+
+// stopCallRing stops tracking the call invitation of the associated channel.
+// Returns true if there was one.
+func (c *Channel) stopCallRing() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.ring == nil {
+		return false
+	}
+	c.ring.stop()
+	c.ring = nil
+	return true
+}
+
+// takeCallRing clears the provided call invitation of the associated channel
+// and returns true if it is still the current one and not yet answered.
+func (c *Channel) takeCallRing(ring *callRing) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.ring != ring || ring.answered != "" {
+		return false
+	}
+	c.ring = nil
+	return true
+}
+
+// cancelCallRing clears the call invitation of the associated channel if it
+// was sent by the provided source and is not yet answered. Returns the ring
+// or nil if there was none.
+func (c *Channel) cancelCallRing(source string) *callRing {
+	c.Lock()
+	defer c.Unlock()
+
+	ring := c.ring
+	if ring == nil || ring.source != source || ring.answered != "" {
+		return nil
+	}
+	ring.stop()
+	c.ring = nil
+	return ring
+}
+
+// answerCallRing records the answer of the provided target to the call
+// invitation of the associated channel. The first accept wins. Returns the
+// ring if this answer ends it and true if the answer is to be processed and
+// forwarded to the caller.
+func (c *Channel) answerCallRing(target string, accept bool) (*callRing, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	ring := c.ring
+	if ring == nil || !ring.has(target) {
+		return nil, true
+	}
+	if ring.answered != "" {
+		// Answered already, only the one who did continues.
+		return nil, ring.answered == target
+	}
+	if accept {
+		ring.answered = target
+		ring.stop()
+		return ring, true
+	}
+
+	// Rejects are only forwarded, when all targets have rejected.
+	ring.rejected[target] = true
+	if len(ring.rejected) < len(ring.targets) {
+		return nil, false
+	}
+	ring.stop()
+	c.ring = nil
+	return ring, true
+}
+
+// hangupCallRing hangs up the unanswered call of the provided channel at all
+// its targets and at the caller if notifySource is true, then removes the
+// channel.
+func (m *Manager) hangupCallRing(channel *Channel, ring *callRing, reason string, notifySource bool) {
+	data, err := json.MarshalIndent(&api.RTMDataWebRTCHangup{
+		Reason: reason,
+	}, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).Errorln("failed to encode call hangup data")
+		return
+	}
+
+	// Hang up at the caller.
+	if c, _ := channel.Get(ring.source); c != nil && notifySource {
+		peer := ring.peer(channel)
+		c.Send(&api.RTMTypeWebRTC{
+			RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+				Type:    api.RTMTypeNameWebRTC,
+				Subtype: api.RTMSubtypeNameWebRTCHangup,
+			},
+			Source:  peer,
+			Target:  ring.source,
+			Channel: channel.id,
+			Hash:    base64.StdEncoding.EncodeToString(computeWebRTCChannelHash(api.RTMTypeNameWebRTC, ring.source, peer, channel.id)),
+			Version: currentWebRTCPayloadVersion,
+			Data:    data,
+		})
+	}
+	// Hang up at all connections of the targets, which are still ringing.
+	for _, target := range ring.targets {
+		connections, ok := m.LookupConnectionsByID(target)
+		if !ok {
+			continue
+		}
+		hash := base64.StdEncoding.EncodeToString(computeWebRTCChannelHash(api.RTMTypeNameWebRTC, ring.source, target, channel.id))
+		for _, c := range connections {
+			c.Send(&api.RTMTypeWebRTC{
+				RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+					Type:    api.RTMTypeNameWebRTC,
+					Subtype: api.RTMSubtypeNameWebRTCHangup,
+				},
+				Source:  ring.source,
+				Target:  target,
+				Channel: channel.id,
+				Hash:    hash,
+				Version: currentWebRTCPayloadVersion,
+				Data:    data,
+			})
+		}
+	}
+
+	// Clean up right away.
+	channel.Remove(ring.source)
+	if channel.Cleanup() {
+		m.channels.Remove(channel.id)
+	}
+}
+
+// sendCallRingAnswered sends the channel with the hash for the target who
+// answered the provided ring to its caller, replacing the hash which used the
+// channel as peer.
+func (m *Manager) sendCallRingAnswered(channel *Channel, ring *callRing) {
+	c, _ := channel.Get(ring.source)
+	if c == nil {
+		return
+	}
+
+	c.Send(&api.RTMTypeWebRTC{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCChannel,
+		},
+		Source:  ring.answered,
+		Target:  ring.source,
+		Channel: channel.id,
+		Hash:    base64.StdEncoding.EncodeToString(computeWebRTCChannelHash(api.RTMTypeNameWebRTC, ring.source, ring.answered, channel.id)),
+		Version: currentWebRTCPayloadVersion,
+	})
+}
+
+// sendCallRingRejected sends a single reject for all targets of the provided
+// ring to its caller, once all of them have rejected. The reject uses the
+// channel as peer, as the hash of the call sent to the caller does.
+func (m *Manager) sendCallRingRejected(channel *Channel, ring *callRing, extra *api.RTMDataWebRTCAccept) error {
+	c, _ := channel.Get(ring.source)
+	if c == nil {
+		return nil
+	}
+
+	rejected := make([]string, 0, len(ring.targets))
+	for _, target := range ring.targets {
+		if ring.rejected[target] {
+			rejected = append(rejected, target)
+		}
+	}
+	data, err := json.MarshalIndent(&api.RTMDataWebRTCAccept{
+		Accept:   false,
+		Reason:   extra.Reason,
+		Rejected: rejected,
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	peer := ring.peer(channel)
+	return c.Send(&api.RTMTypeWebRTC{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCCall,
+		},
+		Source:  peer,
+		Target:  ring.source,
+		Channel: channel.id,
+		Hash:    base64.StdEncoding.EncodeToString(computeWebRTCChannelHash(api.RTMTypeNameWebRTC, ring.source, peer, channel.id)),
+		Version: currentWebRTCPayloadVersion,
+		Data:    data,
+	})
+}
+
+// clearCallRing lets all targets of the provided ring except the one who
+// answered know that the call was answered elsewhere.
+func (m *Manager) clearCallRing(channel *Channel, ring *callRing) {
+	clearedMsg := &api.RTMTypeWebRTC{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCCall,
+		},
+		Initiator: true,
+		Channel:   channel.id,
+		Source:    ring.source,
+		Version:   currentWebRTCPayloadVersion,
+	}
+	for _, target := range ring.targets {
+		if target == ring.answered {
+			continue
+		}
+		connections, ok := m.LookupConnectionsByID(target)
+		if !ok {
+			continue
+		}
+		for _, c := range connections {
+			c.Send(clearedMsg)
+		}
+	}
+}
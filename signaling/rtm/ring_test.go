@@ -0,0 +1,217 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+type callRingAnswer struct {
+	target  string
+	accept  bool
+	ends    bool
+	forward bool
+}
+
+func TestAnswerCallRing(t *testing.T) {
+	tests := []struct {
+		name     string
+		targets  []string
+		answers  []callRingAnswer
+		answered string
+	}{
+		{
+			"single accept",
+			[]string{"user2"},
+			[]callRingAnswer{
+				{"user2", true, true, true},
+			},
+			"user2",
+		},
+		{
+			"single reject",
+			[]string{"user2"},
+			[]callRingAnswer{
+				{"user2", false, true, true},
+			},
+			"",
+		},
+		{
+			"first accept wins",
+			[]string{"user2", "user3", "user4"},
+			[]callRingAnswer{
+				{"user3", true, true, true},
+				{"user2", true, false, false},
+				{"user3", true, false, true},
+				{"user4", false, false, false},
+			},
+			"user3",
+		},
+		{
+			"reject then accept",
+			[]string{"user2", "user3"},
+			[]callRingAnswer{
+				{"user2", false, false, false},
+				{"user3", true, true, true},
+			},
+			"user3",
+		},
+		{
+			"all reject",
+			[]string{"user2", "user3", "user4"},
+			[]callRingAnswer{
+				{"user2", false, false, false},
+				{"user2", false, false, false},
+				{"user4", false, false, false},
+				{"user3", false, true, true},
+			},
+			"",
+		},
+		{
+			"not a target",
+			[]string{"user2", "user3"},
+			[]callRingAnswer{
+				{"user5", true, false, true},
+				{"user2", true, true, true},
+			},
+			"user2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			m := newTestManager(ctx)
+			channel := CreateRandomChannel(m, nil)
+			m.startCallRing(channel, "user1", test.targets, len(test.targets) > 1)
+			ring := channel.ring
+
+			for idx, answer := range test.answers {
+				result, forward := channel.answerCallRing(answer.target, answer.accept)
+				if (result != nil) != answer.ends {
+					t.Errorf("%d: ring ends %v, expected %v", idx, result != nil, answer.ends)
+				}
+				if result != nil && result != ring {
+					t.Errorf("%d: returned ring is not the started ring", idx)
+				}
+				if forward != answer.forward {
+					t.Errorf("%d: forward %v, expected %v", idx, forward, answer.forward)
+				}
+			}
+			if ring.answered != test.answered {
+				t.Errorf("answered %q, expected %q", ring.answered, test.answered)
+			}
+		})
+	}
+}
+
+func TestCallRingPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := newTestManager(ctx)
+	channel := CreateRandomChannel(m, nil)
+
+	tests := []struct {
+		targets []string
+		multi   bool
+		peer    string
+	}{
+		{[]string{"user2"}, false, "user2"},
+		{[]string{"user2"}, true, channel.id},
+		{[]string{"user2", "user3"}, true, channel.id},
+	}
+
+	for idx, test := range tests {
+		ring := &callRing{
+			source:  "user1",
+			targets: test.targets,
+			multi:   test.multi,
+		}
+		if peer := ring.peer(channel); peer != test.peer {
+			t.Errorf("%d: peer %q, expected %q", idx, peer, test.peer)
+		}
+	}
+}
+
+func TestSendCallRingRejected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := newTestManager(ctx)
+	channel := CreateRandomChannel(m, nil)
+
+	c := addTestChannelMember(t, channel, "user1", nil)
+	var sent [][]byte
+	c.SetSendFilter(func(c *connection.Connection, record connection.SendRecord) []connection.SendRecord {
+		sent = append(sent, record.Payload)
+		return nil
+	})
+
+	ring := &callRing{
+		source:   "user1",
+		targets:  []string{"user2", "user3", "user4"},
+		multi:    true,
+		rejected: make(map[string]bool),
+	}
+	channel.ring = ring
+	for _, target := range []string{"user4", "user2", "user3"} {
+		ring, forward := channel.answerCallRing(target, false)
+		if ring == nil || !forward {
+			continue
+		}
+		if err := m.sendCallRingRejected(channel, ring, &api.RTMDataWebRTCAccept{
+			Reason: "reject",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, expected 1", len(sent))
+	}
+	var msg api.RTMTypeWebRTC
+	if err := json.Unmarshal(sent[0], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Source != channel.id {
+		t.Errorf("source %q, expected channel %q", msg.Source, channel.id)
+	}
+	if hash := base64.StdEncoding.EncodeToString(computeWebRTCChannelHash(api.RTMTypeNameWebRTC, "user1", ring.peer(channel), channel.id)); msg.Hash != hash {
+		t.Errorf("hash %q does not match hash of the ring %q", msg.Hash, hash)
+	}
+	var extra api.RTMDataWebRTCAccept
+	if err := json.Unmarshal(msg.Data, &extra); err != nil {
+		t.Fatal(err)
+	}
+	if extra.Accept || extra.Reason != "reject" {
+		t.Errorf("unexpected accept data %+v", extra)
+	}
+	if !reflect.DeepEqual(extra.Rejected, ring.targets) {
+		t.Errorf("rejected %v, expected %v", extra.Rejected, ring.targets)
+	}
+}
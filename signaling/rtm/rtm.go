@@ -29,8 +29,19 @@ const (
 	channelIDSize          = 24
 	channelExpiration      = time.Duration(1) * time.Minute
 	activeUserExpiration   = time.Duration(30) * time.Second
+	sessionTokenSize       = 32
+	sessionResumeGrace     = time.Duration(30) * time.Second
 
 	// Buffer sizes.
 	websocketReadBufferSize  = 1024
 	websocketWriteBufferSize = 1024
+	sessionReplaySize        = 128
+)
+
+// Connection limit policies, applied when a user exceeds the maximum number of
+// concurrent connections.
+const (
+	ConnectionLimitPolicyReject      = "reject"
+	ConnectionLimitPolicyEvictOldest = "evict-oldest"
+	ConnectionLimitPolicyEvictIdle   = "evict-idle"
 )
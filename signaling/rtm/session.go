@@ -0,0 +1,473 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"stash.kopano.io/kgol/rndm"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// sessionRecord binds a resumable RTM session to the connection which is
+// currently serving it. When a connection drops, its channel memberships are
+// held by the session for a grace period, so a new connection of the same
+// user can take them over without leaving and joining again.
+//
+// All messages sent to the connections of a session are stamped with a
+// sequence number. The most recent chats and webrtc messages are kept in a
+// bounded replay buffer, so they can be sent again when a session is resumed.
+type sessionRecord struct {
+	sync.Mutex
+
+	id     string
+	userID string
+
+	connection *connection.Connection
+	superseded *connection.Connection
+	resumed    bool
+	expire     *time.Timer
+
+	memberships []*sessionMembership
+
+	seq        uint64
+	replay     []*sessionReplayEntry
+	replaySize int
+	evicted    uint64
+	replayFrom uint64
+	replaying  bool
+}
+
+// sessionRequest holds what a connection asked for when connecting, until the
+// connection is accepted and its session gets attached.
+type sessionRequest struct {
+	userID    string
+	resume    string
+	resumeSeq uint64
+}
+
+type sessionMembership struct {
+	id         string
+	channel    *Channel
+	connection *connection.Connection
+}
+
+type sessionReplayEntry struct {
+	seq    uint64
+	record connection.SendRecord
+}
+
+var sessionSeqPrefix = []byte(`{"seq":`)
+
+// sessionEnvelopeSize is the number of leading payload bytes which are looked
+// at to find the envelope. Envelopes are always marshalled first.
+const sessionEnvelopeSize = 128
+
+// attachSession binds the provided connection to a session. If resume is the
+// token of an existing session of the same user, that session is taken over
+// and all its buffered messages after resumeSeq are replayed, otherwise a new
+// session is created.
+func (m *Manager) attachSession(c *connection.Connection, userID string, resume string, resumeSeq uint64) *sessionRecord {
+	var session *sessionRecord
+	var superseded *connection.Connection
+
+	if resume != "" {
+		if record, ok := m.sessions.Pop(resume); ok {
+			session = record.(*sessionRecord)
+			session.Lock()
+			if session.userID != userID {
+				// Never hand out sessions of other users.
+				c.Logger().Warnln("session resume with token of other user")
+				session.Unlock()
+				m.sessions.Set(resume, session)
+				session = nil
+			} else {
+				if session.expire != nil {
+					session.expire.Stop()
+					session.expire = nil
+				}
+				if session.connection != nil && !session.connection.IsClosed() {
+					// Old connection did not notice yet that it is gone.
+					superseded = session.connection
+					session.superseded = superseded
+				}
+				session.id = rndm.GenerateRandomString(sessionTokenSize)
+				session.connection = c
+				session.resumed = true
+				session.replayFrom = resumeSeq
+				session.replaying = true
+				session.Unlock()
+			}
+		} else {
+			c.Logger().Debugln("session resume with unknown or expired token")
+		}
+	}
+
+	if session == nil {
+		session = &sessionRecord{
+			id:         rndm.GenerateRandomString(sessionTokenSize),
+			userID:     userID,
+			connection: c,
+			replaySize: m.sessionReplaySize,
+		}
+	}
+
+	m.sessions.Set(session.id, session)
+	m.connectionSessions.Set(c.ID(), session)
+	c.SetSendFilter(session.filter)
+
+	if superseded != nil {
+		// Close the old connection. Its channel memberships are held by the
+		// session, since it is marked as superseded.
+		superseded.Close()
+	}
+
+	return session
+}
+
+// requestSession records the session request of the provided connection. The
+// session is attached once the connection is accepted.
+func (m *Manager) requestSession(c *connection.Connection, userID string, resume string, resumeSeq uint64) {
+	m.pendingSessions.Set(c.ID(), &sessionRequest{
+		userID:    userID,
+		resume:    resume,
+		resumeSeq: resumeSeq,
+	})
+}
+
+// popSessionRequest removes and returns the session request of the provided
+// connection.
+func (m *Manager) popSessionRequest(c *connection.Connection) *sessionRequest {
+	record, ok := m.pendingSessions.Pop(c.ID())
+	if !ok {
+		return nil
+	}
+
+	return record.(*sessionRequest)
+}
+
+// resumingConnection returns the connection which is taken over when the
+// provided session request is accepted, or nil.
+func (m *Manager) resumingConnection(request *sessionRequest) *connection.Connection {
+	if request == nil || request.resume == "" {
+		return nil
+	}
+	record, ok := m.sessions.Get(request.resume)
+	if !ok {
+		return nil
+	}
+	session := record.(*sessionRecord)
+
+	session.Lock()
+	defer session.Unlock()
+	if session.userID != request.userID || session.connection == nil || session.connection.IsClosed() {
+		return nil
+	}
+
+	return session.connection
+}
+
+// lookupSession returns the session of the provided connection.
+func (m *Manager) lookupSession(c *connection.Connection) (*sessionRecord, bool) {
+	record, ok := m.connectionSessions.Get(c.ID())
+	if !ok {
+		return nil, false
+	}
+
+	return record.(*sessionRecord), true
+}
+
+// isSupersededConnection returns true if the provided connection was taken
+// over by a resumed session.
+func (m *Manager) isSupersededConnection(c *connection.Connection) bool {
+	session, ok := m.lookupSession(c)
+	if !ok {
+		return false
+	}
+
+	session.Lock()
+	defer session.Unlock()
+	return session.superseded == c
+}
+
+// holdSessionMembership is called when the provided connection was closed
+// while being a member of the provided channel. It returns true, if the
+// membership is held by the connection's session for resumption.
+func (m *Manager) holdSessionMembership(c *connection.Connection, channel *Channel, id string) bool {
+	session, ok := m.lookupSession(c)
+	if !ok {
+		return false
+	}
+
+	session.Lock()
+	defer session.Unlock()
+
+	if session.superseded != c && (session.connection != c || !c.Dropped()) {
+		return false
+	}
+
+	session.memberships = append(session.memberships, &sessionMembership{
+		id:         id,
+		channel:    channel,
+		connection: c,
+	})
+	c.Logger().WithField("channel", channel.id).Debugln("session holds channel membership")
+	return true
+}
+
+// resumeSession moves all channel memberships held by the session of the
+// provided connection over to the provided connection.
+func (m *Manager) resumeSession(c *connection.Connection) {
+	session, ok := m.lookupSession(c)
+	if !ok {
+		return
+	}
+
+	session.Lock()
+	if session.connection != c {
+		session.Unlock()
+		return
+	}
+	memberships := session.memberships
+	session.memberships = nil
+	session.superseded = nil
+	session.Unlock()
+
+	for _, membership := range memberships {
+		if err := membership.channel.reattach(membership.id, membership.connection, c); err != nil {
+			c.Logger().WithError(err).WithField("channel", membership.channel.id).Debugln("session resume skipped channel")
+		}
+	}
+}
+
+// detachSession unbinds the provided connection from its session. If the
+// connection dropped, the session is kept until the resume grace period is
+// over.
+func (m *Manager) detachSession(c *connection.Connection) {
+	m.pendingSessions.Remove(c.ID())
+
+	record, ok := m.connectionSessions.Pop(c.ID())
+	if !ok {
+		return
+	}
+	session := record.(*sessionRecord)
+
+	session.Lock()
+	if session.connection != c {
+		// Session was taken over by another connection already.
+		session.Unlock()
+		return
+	}
+	if c.Dropped() {
+		session.expire = time.AfterFunc(m.sessionResumeGrace, func() {
+			m.expireSession(session, c)
+		})
+		session.Unlock()
+		c.Logger().Debugln("session detached")
+		return
+	}
+	session.Unlock()
+
+	m.expireSession(session, c)
+}
+
+// expireSession removes the provided session and releases all its held
+// channel memberships, if the session is still bound to the provided
+// connection.
+func (m *Manager) expireSession(session *sessionRecord, c *connection.Connection) {
+	session.Lock()
+	if session.connection != c {
+		session.Unlock()
+		return
+	}
+	memberships := session.memberships
+	session.memberships = nil
+	session.expire = nil
+	m.sessions.RemoveCb(session.id, func(key string, v interface{}, exists bool) bool {
+		return exists && v == session
+	})
+	session.Unlock()
+
+	for _, membership := range memberships {
+		membership.channel.Lock()
+		membership.channel.remove(membership.id, membership.connection)
+		membership.channel.Unlock()
+	}
+
+	if len(memberships) > 0 {
+		m.logger.WithFields(logrus.Fields{
+			"user_id":  session.userID,
+			"channels": len(memberships),
+		}).Debugln("session expired")
+	}
+}
+
+// resumeData returns the resume data of the session of the provided
+// connection, to be sent to the client.
+func (m *Manager) resumeData(c *connection.Connection) *api.RTMDataResume {
+	session, ok := m.lookupSession(c)
+	if !ok {
+		return nil
+	}
+
+	session.Lock()
+	defer session.Unlock()
+
+	return &api.RTMDataResume{
+		Token:      session.id,
+		Resumed:    session.resumed,
+		Grace:      int64(m.sessionResumeGrace / time.Second),
+		Incomplete: session.replaying && session.replayFrom < session.evicted,
+	}
+}
+
+// filter is the send filter of all connections of the accociated session. It
+// stamps the sequence number into the payload and records replayable messages.
+// The first message sent to a resuming connection is preceded by the replay, so
+// sequence numbers never go backwards.
+// Messages for connections which were taken over already are forwarded to the
+// current connection of the session once its replay was sent. Written
+// callbacks stay with their payload, so they also fire for replayed and
+// forwarded messages.
+func (session *sessionRecord) filter(c *connection.Connection, record connection.SendRecord) []connection.SendRecord {
+	if bytes.HasPrefix(record.Payload, sessionSeqPrefix) {
+		// Already has a sequence number (forwarded), pass as is.
+		return []connection.SendRecord{record}
+	}
+
+	replayable := isReplayablePayload(record.Payload)
+
+	session.Lock()
+	session.seq++
+	stamped := connection.SendRecord{
+		Payload: stampSequence(record.Payload, session.seq),
+		Written: record.Written,
+	}
+
+	var replay []*sessionReplayEntry
+	if c == session.connection && session.replaying {
+		for idx, entry := range session.replay {
+			if entry.seq > session.replayFrom {
+				replay = session.replay[idx:]
+				break
+			}
+		}
+		session.replaying = false
+	}
+	if replayable {
+		if len(session.replay) >= session.replaySize {
+			session.evicted = session.replay[0].seq
+			session.replay = session.replay[1:]
+		}
+		session.replay = append(session.replay, &sessionReplayEntry{session.seq, stamped})
+	}
+	current := session.connection
+	forward := replayable && c != current && current != nil && !session.replaying
+	session.Unlock()
+
+	if forward {
+		// NOTE(longsleep): Forward outside of the session lock since the send
+		// filter of the current connection needs it as well.
+		current.RawSendWithWritten(stamped.Payload, stamped.Written)
+		return nil
+	}
+
+	result := make([]connection.SendRecord, 0, len(replay)+1)
+	for _, entry := range replay {
+		result = append(result, entry.record)
+	}
+	result = append(result, stamped)
+	if len(replay) > 0 {
+		c.Logger().WithField("count", len(replay)).Debugln("session replay")
+	}
+	return result
+}
+
+// stampSequence returns a copy of the provided JSON object payload with the
+// provided sequence number added as first field.
+func stampSequence(payload []byte, seq uint64) []byte {
+	if len(payload) == 0 || payload[0] != '{' {
+		return payload
+	}
+
+	stamped := make([]byte, 0, len(payload)+len(sessionSeqPrefix)+21)
+	stamped = append(stamped, sessionSeqPrefix...)
+	stamped = strconv.AppendUint(stamped, seq, 10)
+	if rest := bytes.TrimSpace(payload[1:]); len(rest) == 0 || rest[0] != '}' {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, payload[1:]...)
+}
+
+// isReplayablePayload returns true if the provided payload is a message of a
+// type which gets replayed when a session is resumed. Ephemeral messages like
+// typing notifications are never replayed.
+func isReplayablePayload(payload []byte) bool {
+	msgType, subtype := payloadEnvelope(payload)
+	switch msgType {
+	case api.RTMTypeNameChats:
+		return subtype != api.RTMSubtypeNameChatsTyping
+	case api.RTMTypeNameWebRTC:
+		return true
+	default:
+		return false
+	}
+}
+
+// payloadEnvelope returns the type and subtype of the provided JSON object
+// payload. Only the leading bytes of the payload are decoded, stopping at the
+// first nested value.
+func payloadEnvelope(payload []byte) (string, string) {
+	if len(payload) > sessionEnvelopeSize {
+		payload = payload[:sessionEnvelopeSize]
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return "", ""
+	}
+
+	var msgType, subtype string
+	for msgType == "" || subtype == "" {
+		key, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		value, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		if _, nested := value.(json.Delim); nested {
+			break
+		}
+		switch key {
+		case "type":
+			msgType, _ = value.(string)
+		case "subtype":
+			subtype, _ = value.(string)
+		}
+	}
+
+	return msgType, subtype
+}
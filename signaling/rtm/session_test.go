@@ -0,0 +1,269 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+func newTestLogger() logrus.FieldLogger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	return logger
+}
+
+func newTestManager(ctx context.Context) *Manager {
+	return NewManager(ctx, "test", true, nil, "", newTestLogger(), nil, nil, nil, nil, nil)
+}
+
+func newTestConnection(t *testing.T, id string) *connection.Connection {
+	c, err := connection.New(context.Background(), nil, nil, newTestLogger(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestAttachSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		resume  string
+		resumed bool
+	}{
+		{"new", "user1", "", false},
+		{"unknown token", "user1", "unknown", false},
+		{"other user", "user2", "existing", false},
+		{"same user", "user1", "existing", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			m := newTestManager(ctx)
+			existing := m.attachSession(newTestConnection(t, "c1"), "user1", "", 0)
+			token := existing.id
+
+			resume := test.resume
+			if resume == "existing" {
+				resume = token
+			}
+			session := m.attachSession(newTestConnection(t, "c2"), test.user, resume, 0)
+
+			if session.resumed != test.resumed {
+				t.Errorf("expected resumed %v, got %v", test.resumed, session.resumed)
+			}
+			if (session == existing) != test.resumed {
+				t.Errorf("expected existing session %v", test.resumed)
+			}
+			if session.userID != test.user {
+				t.Errorf("expected session of %v, got %v", test.user, session.userID)
+			}
+			if session.id == token {
+				t.Errorf("expected new token for session")
+			}
+			// Tokens of other users stay valid for their owner.
+			if _, ok := m.sessions.Get(token); ok == test.resumed {
+				t.Errorf("expected old token valid %v", !test.resumed)
+			}
+		})
+	}
+}
+
+func TestResumingConnection(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		resume   string
+		resuming bool
+	}{
+		{"new", "user1", "", false},
+		{"unknown token", "user1", "unknown", false},
+		{"other user", "user2", "existing", false},
+		{"same user", "user1", "existing", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			m := newTestManager(ctx)
+			c1 := newTestConnection(t, "c1")
+			existing := m.attachSession(c1, "user1", "", 0)
+
+			resume := test.resume
+			if resume == "existing" {
+				resume = existing.id
+			}
+			c2 := newTestConnection(t, "c2")
+			m.requestSession(c2, test.user, resume, 0)
+			request := m.popSessionRequest(c2)
+
+			resuming := m.resumingConnection(request)
+			if (resuming == c1) != test.resuming {
+				t.Errorf("expected resuming %v, got %v", test.resuming, resuming)
+			}
+			if existing.connection != c1 || c1.IsClosed() {
+				t.Errorf("expected session to stay with its connection until accepted")
+			}
+		})
+	}
+}
+
+func TestStampSequence(t *testing.T) {
+	tests := []struct {
+		in  string
+		seq uint64
+		out string
+	}{
+		{`{"type":"chats"}`, 1, `{"seq":1,"type":"chats"}`},
+		{"{\n\t\"type\": \"chats\"\n}", 42, "{\"seq\":42,\n\t\"type\": \"chats\"\n}"},
+		{`{}`, 7, `{"seq":7}`},
+		{"{ }", 7, `{"seq":7 }`},
+		{`[1,2]`, 1, `[1,2]`},
+		{``, 1, ``},
+	}
+
+	for _, test := range tests {
+		out := string(stampSequence([]byte(test.in), test.seq))
+		if out != test.out {
+			t.Errorf("stamp %q: expected %q, got %q", test.in, test.out, out)
+		}
+		if len(out) > 0 && out[0] == '{' && !json.Valid([]byte(out)) {
+			t.Errorf("stamp %q: invalid json %q", test.in, out)
+		}
+	}
+}
+
+func TestIsReplayablePayload(t *testing.T) {
+	tests := []struct {
+		message    interface{}
+		replayable bool
+	}{
+		{&api.RTMTypeChats{RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{ID: 1, Type: api.RTMTypeNameChats, Subtype: api.RTMSubtypeNameChatsMessage}}, true},
+		{&api.RTMTypeChats{RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{Type: api.RTMTypeNameChats, Subtype: api.RTMSubtypeNameChatsTyping}}, false},
+		{&api.RTMTypeWebRTC{RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{Type: api.RTMTypeNameWebRTC, Subtype: api.RTMSubtypeNameWebRTCSignal}}, true},
+		{&api.RTMTypeWebRTCReply{RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{Type: api.RTMTypeNameWebRTC, Subtype: api.RTMSubtypeNameWebRTCChannel}}, true},
+		{&api.RTMTypeEnvelope{Type: api.RTMTypeNameServer}, false},
+		{&api.RTMTypeHello{Type: api.RTMTypeNameHello, Self: &api.Self{ID: "user1"}}, false},
+		{[]string{"chats"}, false},
+	}
+
+	for idx, test := range tests {
+		payload, err := json.MarshalIndent(test.message, "", "\t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replayable := isReplayablePayload(payload); replayable != test.replayable {
+			t.Errorf("%d: expected replayable %v, got %v for %s", idx, test.replayable, replayable, payload)
+		}
+	}
+}
+
+func TestPayloadEnvelopeMarshalledFirst(t *testing.T) {
+	profile := &api.RTMDataProfile{Name: strings.Repeat("n", sessionEnvelopeSize)}
+	data := json.RawMessage(`{"nested":{"value":true}}`)
+
+	tests := []struct {
+		message interface{}
+		subtype string
+	}{
+		{&api.RTMTypeChats{RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{ID: 1, Type: api.RTMTypeNameChats, Subtype: api.RTMSubtypeNameChatsMessage}, Channel: "channel1", Profile: profile, Data: data}, api.RTMSubtypeNameChatsMessage},
+		{&api.RTMTypeChatsReply{RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{Type: api.RTMTypeNameChats, Subtype: api.RTMSubtypeNameChatsMessage, ReplyTo: 1}, Data: data}, api.RTMSubtypeNameChatsMessage},
+		{&api.RTMTypeWebRTC{RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{ID: 3, Type: api.RTMTypeNameWebRTC, Subtype: api.RTMSubtypeNameWebRTCSignal}, Target: "user2", Source: "user1", Profile: profile, Data: data}, api.RTMSubtypeNameWebRTCSignal},
+		{&api.RTMTypeWebRTCReply{RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{Type: api.RTMTypeNameWebRTC, Subtype: api.RTMSubtypeNameWebRTCChannel, ReplyTo: 3}, Data: data}, api.RTMSubtypeNameWebRTCChannel},
+	}
+
+	for idx, test := range tests {
+		payload, err := json.Marshal(test.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgType, subtype := payloadEnvelope(stampSequence(payload, 1))
+		if msgType == "" || subtype != test.subtype {
+			t.Errorf("%d: expected envelope fields first, got type %q and subtype %q for %s", idx, msgType, subtype, payload)
+		}
+	}
+}
+
+func TestSessionFilterReplay(t *testing.T) {
+	c1 := newTestConnection(t, "c1")
+	session := &sessionRecord{
+		id:         "session",
+		userID:     "user1",
+		connection: c1,
+		replaySize: sessionReplaySize,
+	}
+
+	chats := []byte(`{"type":"chats","subtype":"chats_message"}`)
+	typing := []byte(`{"type":"chats","subtype":"chats_typing"}`)
+	hello := []byte(`{"type":"hello"}`)
+
+	// Seq 1 to 5, with 1, 3 and 5 being replayable.
+	for _, payload := range [][]byte{chats, typing, chats, hello, chats} {
+		if records := session.filter(c1, connection.SendRecord{Payload: payload}); len(records) != 1 {
+			t.Fatalf("expected single record, got %d", len(records))
+		}
+	}
+
+	// Resume after seq 2.
+	c2 := newTestConnection(t, "c2")
+	session.Lock()
+	session.connection = c2
+	session.replayFrom = 2
+	session.replaying = true
+	session.Unlock()
+
+	records := session.filter(c2, connection.SendRecord{Payload: hello})
+	expected := []uint64{3, 5, 6}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(records))
+	}
+	for idx, record := range records {
+		var envelope struct {
+			Seq uint64 `json:"seq"`
+		}
+		if err := json.Unmarshal(record.Payload, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		if envelope.Seq != expected[idx] {
+			t.Errorf("record %d: expected seq %d, got %d", idx, expected[idx], envelope.Seq)
+		}
+	}
+	if !bytes.Contains(records[len(records)-1].Payload, []byte(`"hello"`)) {
+		t.Errorf("expected hello to be sent after replay")
+	}
+
+	// Replay is only sent once.
+	if records = session.filter(c2, connection.SendRecord{Payload: hello}); len(records) != 1 {
+		t.Errorf("expected single record after replay, got %d", len(records))
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+const (
+	maxParticipantStateCustomKeys      = 16
+	maxParticipantStateCustomKeyLength = 64
+	maxParticipantStateCustomValueSize = 256
+)
+
+// updateState applies the provided state update to the state of the member
+// identified by the provided id.
+func (c *Channel) updateState(id string, update *api.RTMDataWebRTCState) error {
+	c.Lock()
+	defer c.Unlock()
+
+	state := &api.RTMDataWebRTCState{}
+	if current, ok := c.states[id]; ok {
+		*state = *current
+		state.Custom = nil
+		for key, value := range current.Custom {
+			if state.Custom == nil {
+				state.Custom = make(map[string]interface{})
+			}
+			state.Custom[key] = value
+		}
+	}
+	if update.Hand != nil {
+		state.Hand = update.Hand
+	}
+	if update.AudioMuted != nil {
+		state.AudioMuted = update.AudioMuted
+	}
+	if update.VideoMuted != nil {
+		state.VideoMuted = update.VideoMuted
+	}
+	if update.Sharing != nil {
+		state.Sharing = update.Sharing
+	}
+	for key, value := range update.Custom {
+		if value == nil {
+			delete(state.Custom, key)
+			continue
+		}
+		if state.Custom == nil {
+			state.Custom = make(map[string]interface{})
+		}
+		state.Custom[key] = value
+	}
+	if len(state.Custom) > maxParticipantStateCustomKeys {
+		return fmt.Errorf("too many custom state keys")
+	}
+
+	c.states[id] = state
+	return nil
+}
+
+// getStates returns a copy of the states of all members of the channel,
+// mapped by member ID.
+func (c *Channel) getStates() map[string]*api.RTMDataWebRTCState {
+	c.RLock()
+	defer c.RUnlock()
+
+	states := make(map[string]*api.RTMDataWebRTCState, len(c.states))
+	for id, state := range c.states {
+		copied := *state
+		if state.Custom != nil {
+			copied.Custom = make(map[string]interface{}, len(state.Custom))
+			for key, value := range state.Custom {
+				copied.Custom[key] = value
+			}
+		}
+		states[id] = &copied
+	}
+
+	return states
+}
+
+func validateWebRTCState(state *api.RTMDataWebRTCState) error {
+	for key, value := range state.Custom {
+		if key == "" || len(key) > maxParticipantStateCustomKeyLength {
+			return fmt.Errorf("invalid custom state key")
+		}
+		if value == nil {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil || len(encoded) > maxParticipantStateCustomValueSize {
+			return fmt.Errorf("custom state value too large")
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) processWebRTCState(c *connection.Connection, msg *api.RTMTypeWebRTC, ur *userRecord) error {
+	// Connection must have a user.
+	if ur == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection has no user", msg.ID)
+	}
+	// Channel and data must not be empty.
+	if msg.Channel == "" || msg.Data == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel or data is empty", msg.ID)
+	}
+	// Source must always be empty when received here.
+	if msg.Source != "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "source must be empty", msg.ID)
+	}
+
+	// Get channel, only group channels have participant state.
+	record, ok := m.channels.Get(msg.Channel)
+	if !ok {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel not found", msg.ID)
+	}
+	channel := record.(*channelRecord).channel
+	if channel.config.Group == "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel has no participant state", msg.ID)
+	}
+
+	// Receiving connection must be in channel.
+	if cc, _ := channel.Get(ur.id); cc != c {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection not in channel", msg.ID)
+	}
+
+	update := &api.RTMDataWebRTCState{}
+	if err := json.Unmarshal(msg.Data, update); err != nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "state data parse error", msg.ID)
+	}
+	if err := validateWebRTCState(update); err != nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
+	}
+	if err := channel.updateState(ur.id, update); err != nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"channel": channel.id,
+	}).Debugln("webrtc participant state update")
+
+	return m.sendWebRTCState(channel, ur.id, update)
+}
+
+// sendWebRTCState sends the provided state update of the member identified by
+// the provided source to all members of the provided channel.
+func (m *Manager) sendWebRTCState(channel *Channel, source string, update *api.RTMDataWebRTCState) error {
+	data, err := json.MarshalIndent(update, "", "\t")
+	if err != nil {
+		return err
+	}
+	payload, err := json.MarshalIndent(&api.RTMTypeWebRTC{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCState,
+		},
+		Source:  source,
+		Target:  channel.config.Group,
+		Channel: channel.id,
+		Group:   channel.config.Group,
+		Version: currentWebRTCPayloadVersion,
+		Data:    data,
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, connections := channel.Connections()
+	for _, connection := range connections {
+		if err = connection.RawSend(payload); err != nil {
+			connection.Logger().WithError(err).WithField("channel", channel.id).Errorln("failed to send participant state to connection")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// processWebRTCTransfer handles transfers of established calls. The sender
+// leaves the call identified by the message channel and its target, the peer,
+// is connected to the transfer target instead.
+func (m *Manager) processWebRTCTransfer(c *connection.Connection, msg *api.RTMTypeWebRTC, ur *userRecord, auth *api.AdminAuthToken) error {
+	// Connection must have a user.
+	if ur == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection has no user", msg.ID)
+	}
+	// Transfers create calls, which must be allowed like for call requests.
+	if auth != nil && !auth.CanCreateChannels {
+		return api.NewRTMTypeError(api.RTMErrorIDCreateRestricted, "access denied", msg.ID)
+	}
+	// State must always be not empty.
+	if msg.State == "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "state is empty", msg.ID)
+	}
+	// Source must always be empty when received here.
+	if msg.Source != "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "source must be empty", msg.ID)
+	}
+	if msg.Channel == "" || msg.Hash == "" || msg.Data == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel hash or data is empty", msg.ID)
+	}
+	if msg.Group != "" {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "group calls cannot be transferred", msg.ID)
+	}
+
+	// Get channel, only 1:1 calls can be transferred.
+	record, ok := m.channels.Get(msg.Channel)
+	if !ok {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel not found", msg.ID)
+	}
+	channel := record.(*channelRecord).channel
+	if !channel.isCall() {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "channel cannot be transferred", msg.ID)
+	}
+	if err := channel.checkWebRTCMessage(ur.id, msg); err != nil {
+		return err
+	}
+
+	// Sending connection and peer must be in channel.
+	if cc, _ := channel.Get(ur.id); cc != c {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection not in channel", msg.ID)
+	}
+	peerConn, ok := channel.Get(msg.Target)
+	if !ok || peerConn == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDNoSessionForUser, "target not found", msg.ID)
+	}
+
+	var transfer *api.RTMDataWebRTCTransfer
+	if err := json.Unmarshal(msg.Data, &transfer); err != nil || transfer == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "transfer data parse error", msg.ID)
+	}
+	if transfer.Target == "" || transfer.Target == ur.id || transfer.Target == msg.Target {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "invalid transfer target", msg.ID)
+	}
+
+	switch transfer.Mode {
+	case api.RTMTransferModeBlind:
+		return m.blindTransfer(c, msg, ur, channel, peerConn, transfer.Target)
+
+	case api.RTMTransferModeAttended:
+		return m.attendedTransfer(c, msg, ur, channel, peerConn, transfer)
+
+	default:
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "unknown transfer mode", msg.ID)
+	}
+}
+
+// isCall returns true if the associated channel is an open 1:1 call.
+func (c *Channel) isCall() bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return !c.closed && c.config.Group == "" && c.pipeline == nil
+}
+
+// blindTransfer invites the provided target into the provided channel on
+// behalf of the peer of the sender, then removes the sender from the channel.
+func (m *Manager) blindTransfer(c *connection.Connection, msg *api.RTMTypeWebRTC, ur *userRecord, channel *Channel, peerConn *connection.Connection, target string) error {
+	connections, ok := m.LookupConnectionsByID(target)
+	if !ok {
+		return api.NewRTMTypeError(api.RTMErrorIDNoSessionForUser, "transfer target not found", msg.ID)
+	}
+	peer := msg.Target
+	hash := base64.StdEncoding.EncodeToString(computeWebRTCChannelHash(api.RTMTypeNameWebRTC, peer, target, channel.id))
+
+	// Let the peer know, then leave.
+	targetUr, _ := connections[0].Bound().(*userRecord)
+	err := m.sendWebRTCTransfer(peerConn, ur.id, peer, channel, hash, msg.State, false, m.getUserProfile(targetUr), &api.RTMDataWebRTCTransfer{
+		Mode:     api.RTMTransferModeBlind,
+		Target:   target,
+		Channel:  channel.id,
+		Replaces: channel.id,
+	})
+	if err != nil {
+		return err
+	}
+	channel.stopCallRing()
+	channel.Remove(ur.id)
+
+	// Call target on behalf of the peer.
+	peerUr, _ := peerConn.Bound().(*userRecord)
+	call := &api.RTMTypeWebRTC{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCCall,
+		},
+		Target:    target,
+		Source:    peer,
+		Profile:   m.getUserProfile(peerUr),
+		Initiator: true,
+		State:     msg.State,
+		Channel:   channel.id,
+		Hash:      hash,
+		Version:   currentWebRTCPayloadVersion,
+	}
+	for _, connection := range connections {
+		connection.Send(call)
+	}
+	m.startCallRing(channel, peer, []string{target}, false)
+
+	c.Logger().WithFields(logrus.Fields{
+		"channel": channel.id,
+		"peer":    peer,
+		"target":  target,
+	}).Debugln("webrtc blind transfer")
+
+	return m.sendWebRTCTransferReply(c, msg, channel)
+}
+
+// attendedTransfer links the peer of the sender in the provided channel with
+// the target of the consultation call of the sender given in the transfer
+// data. The target is moved into the provided channel, the sender leaves both
+// channels and the consultation channel is removed.
+func (m *Manager) attendedTransfer(c *connection.Connection, msg *api.RTMTypeWebRTC, ur *userRecord, channel *Channel, peerConn *connection.Connection, transfer *api.RTMDataWebRTCTransfer) error {
+	if transfer.Channel == "" || transfer.Channel == channel.id {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "invalid consultation channel", msg.ID)
+	}
+	record, ok := m.channels.Get(transfer.Channel)
+	if !ok {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "consultation channel not found", msg.ID)
+	}
+	consultation := record.(*channelRecord).channel
+	if !consultation.isCall() {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "consultation channel cannot be transferred", msg.ID)
+	}
+
+	// Sending connection and target must be in consultation channel.
+	if cc, _ := consultation.Get(ur.id); cc != c {
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection not in consultation channel", msg.ID)
+	}
+	targetConn, ok := consultation.Get(transfer.Target)
+	if !ok || targetConn == nil {
+		return api.NewRTMTypeError(api.RTMErrorIDNoSessionForUser, "transfer target not found", msg.ID)
+	}
+
+	// Move target into channel first, so nothing changes when that fails.
+	if err := channel.Add(transfer.Target, targetConn); err != nil {
+		if err == ErrChannelFull {
+			return api.NewRTMTypeError(api.RTMErrorIDChannelFull, err.Error(), msg.ID)
+		}
+		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
+	}
+
+	// Leave and remove consultation channel.
+	consultation.stopCallRing()
+	consultation.Remove(ur.id)
+	consultation.Remove(transfer.Target)
+	if consultation.Cleanup() {
+		m.channels.Remove(consultation.id)
+	}
+
+	// Leave channel.
+	channel.stopCallRing()
+	channel.Remove(ur.id)
+
+	// Let both know about their new peer, the peer initiates.
+	peer := msg.Target
+	hash := base64.StdEncoding.EncodeToString(computeWebRTCChannelHash(api.RTMTypeNameWebRTC, peer, transfer.Target, channel.id))
+	peerUr, _ := peerConn.Bound().(*userRecord)
+	targetUr, _ := targetConn.Bound().(*userRecord)
+	if err := m.sendWebRTCTransfer(peerConn, ur.id, peer, channel, hash, msg.State, true, m.getUserProfile(targetUr), &api.RTMDataWebRTCTransfer{
+		Mode:     api.RTMTransferModeAttended,
+		Target:   transfer.Target,
+		Channel:  channel.id,
+		Replaces: channel.id,
+	}); err != nil {
+		return err
+	}
+	if err := m.sendWebRTCTransfer(targetConn, ur.id, transfer.Target, channel, hash, msg.State, false, m.getUserProfile(peerUr), &api.RTMDataWebRTCTransfer{
+		Mode:     api.RTMTransferModeAttended,
+		Target:   peer,
+		Channel:  channel.id,
+		Replaces: consultation.id,
+	}); err != nil {
+		return err
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"channel":      channel.id,
+		"consultation": consultation.id,
+		"peer":         peer,
+		"target":       transfer.Target,
+	}).Debugln("webrtc attended transfer")
+
+	return m.sendWebRTCTransferReply(c, msg, channel)
+}
+
+// sendWebRTCTransfer sends a transfer notification from source to the
+// provided connection of target, with the hash and profile of its new peer.
+func (m *Manager) sendWebRTCTransfer(c *connection.Connection, source string, target string, channel *Channel, hash string, state string, initiator bool, profile *api.RTMDataProfile, transfer *api.RTMDataWebRTCTransfer) error {
+	data, err := json.MarshalIndent(transfer, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return c.Send(&api.RTMTypeWebRTC{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCTransfer,
+		},
+		Target:    target,
+		Source:    source,
+		Profile:   profile,
+		Initiator: initiator,
+		State:     state,
+		Channel:   channel.id,
+		Hash:      hash,
+		Version:   currentWebRTCPayloadVersion,
+		Data:      data,
+	})
+}
+
+// sendWebRTCTransferReply confirms the transfer request msg to the provided
+// connection.
+func (m *Manager) sendWebRTCTransferReply(c *connection.Connection, msg *api.RTMTypeWebRTC, channel *Channel) error {
+	return c.Send(&api.RTMTypeWebRTCReply{
+		RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
+			Type:    api.RTMTypeNameWebRTC,
+			Subtype: api.RTMSubtypeNameWebRTCTransfer,
+			ReplyTo: msg.ID,
+		},
+		Channel: channel.id,
+		Version: currentWebRTCPayloadVersion,
+	})
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright 2021 Kopano and its licensors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License, version 3,
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rtm
+
+import (
+	"encoding/json"
+	"time"
+
+	api "stash.kopano.io/kwm/kwmserver/signaling/api-v1"
+	"stash.kopano.io/kwm/kwmserver/signaling/connection"
+)
+
+// chatsTypingThrottle is the minimal duration between two typing indicators
+// of the same sender sent out to a channel.
+const chatsTypingThrottle = time.Duration(3) * time.Second
+
+// chatsTypingExpiration is the duration after which a typing indicator
+// expires when its sender does not refresh it.
+const chatsTypingExpiration = time.Duration(6) * time.Second
+
+// channelTyping is the typing indicator state of a channel member.
+type channelTyping struct {
+	when    time.Time
+	timer   *time.Timer
+	source  *connection.Connection
+	profile *api.RTMDataProfile
+}
+
+// updateChannelChatsTyping sets or clears the typing indicator of the channel
+// member identified by id, sending it to all other connections of the channel
+// unless throttled.
+func (m *Manager) updateChannelChatsTyping(channel *Channel, c *connection.Connection, id string, profile *api.RTMDataProfile, typing bool) {
+	channel.namedMutexLock(channelMutexChats)
+	defer channel.namedMutexUnlock(channelMutexChats)
+
+	record := channel.typing[id]
+	if !typing {
+		if record == nil {
+			// Not typing, nothing to do.
+			return
+		}
+		record.timer.Stop()
+		delete(channel.typing, id)
+		m.sendChannelChatsTyping(channel, record.source, id, record.profile, false)
+		return
+	}
+
+	now := time.Now()
+	if record == nil {
+		record = &channelTyping{}
+		channel.typing[id] = record
+	} else {
+		record.timer.Stop()
+	}
+	record.source = c
+	record.profile = profile
+	current := record
+	record.timer = time.AfterFunc(chatsTypingExpiration, func() {
+		m.expireChannelChatsTyping(channel, id, current)
+	})
+
+	if now.Sub(record.when) < chatsTypingThrottle {
+		// Throttled, expiration got extended.
+		return
+	}
+	record.when = now
+	m.sendChannelChatsTyping(channel, c, id, profile, true)
+}
+
+// expireChannelChatsTyping clears the provided typing indicator of the channel
+// member identified by id, if it is still current.
+func (m *Manager) expireChannelChatsTyping(channel *Channel, id string, record *channelTyping) {
+	channel.namedMutexLock(channelMutexChats)
+	defer channel.namedMutexUnlock(channelMutexChats)
+
+	if channel.typing[id] != record {
+		return
+	}
+	delete(channel.typing, id)
+	m.sendChannelChatsTyping(channel, record.source, id, record.profile, false)
+}
+
+// clearChannelChatsTyping silently clears the typing indicator of the channel
+// member identified by id. Receivers clear typing indicators on their own when
+// they receive a message of the sender. The caller must hold the channel's
+// chats mutex.
+func (m *Manager) clearChannelChatsTyping(channel *Channel, id string) {
+	if record := channel.typing[id]; record != nil {
+		record.timer.Stop()
+		delete(channel.typing, id)
+	}
+}
+
+// sendChannelChatsTyping sends a typing indicator to all connections of the
+// provided channel, except the provided source connection. The caller must
+// hold the channel's chats mutex.
+func (m *Manager) sendChannelChatsTyping(channel *Channel, source *connection.Connection, id string, profile *api.RTMDataProfile, typing bool) {
+	extra := &api.RTMDataChatsTyping{
+		Sender: id,
+		Typing: typing,
+	}
+	if typing {
+		extra.Expires = int64(chatsTypingExpiration / time.Second)
+	}
+
+	// Encode payload (only once, same message for everyone).
+	message, err := json.MarshalIndent(extra, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode channel chats typing message")
+		return
+	}
+	payload, err := json.MarshalIndent(&api.RTMTypeChats{
+		RTMTypeSubtypeEnvelope: &api.RTMTypeSubtypeEnvelope{
+			Type:    api.RTMTypeNameChats,
+			Subtype: api.RTMSubtypeNameChatsTyping,
+		},
+		Channel: channel.id,
+		Profile: profile,
+		Data:    message,
+		Version: currentChatsPayloadVersion,
+	}, "", "\t")
+	if err != nil {
+		m.logger.WithError(err).WithField("channel", channel.id).Errorln("failed to encode channel chats typing data")
+		return
+	}
+
+	// Loop through channel connections, sending out payload.
+	_, connections := channel.Connections()
+	for _, connection := range connections {
+		if connection == source {
+			// Skip sending message to sender (self).
+			continue
+		}
+		err = connection.RawSend(payload)
+		if err != nil {
+			connection.Logger().WithError(err).WithField("channel", channel.id).Debugln("failed to send channel chats typing to connection")
+		}
+	}
+}
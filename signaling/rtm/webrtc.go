@@ -40,6 +40,11 @@ const minimalWebRTCPayloadVersion uint64 = 0
 // with kwmjs.
 const currentWebRTCPayloadVersion uint64 = 20180703
 
+// profilesWebRTCPayloadVersion defines the WebRTC payload version which clients
+// need to send when joining groups, to receive member profiles with group
+// updates.
+const profilesWebRTCPayloadVersion uint64 = 20211101
+
 var webrtcChannelHashKey []byte
 
 func init() {
@@ -155,97 +160,80 @@ func (m *Manager) processWebRTCMessage(c *connection.Connection, msg *api.RTMTyp
 		if err != nil {
 			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
 		}
-		// Get or create channel with ID.
-		record := m.channels.Upsert(channelID, nil, func(exists bool, valueInMap interface{}, newValue interface{}) interface{} {
-			if exists && valueInMap != nil {
-				return valueInMap
+		// Ensure that scheduled meetings of the group allow to join now.
+		meeting, err := m.getGroupMeeting(msg)
+		if err != nil {
+			return err
+		}
+		maxParticipants := m.getGroupMaxParticipants(msg.Group, auth)
+		if meeting != nil {
+			if !meeting.Allows(ur.id, auth) && (auth == nil || auth.IsGuest() || !auth.IsModerator(msg.Group)) {
+				return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "not invited to meeting", msg.ID)
 			}
-			newChannel := CreateKnownChannel(channelID, m, &ChannelConfig{
-				Group: msg.Group,
-
-				Replace:          m.onGroupReplace,
-				AfterAddOrRemove: m.onAfterGroupAddOrRemove,
-				AfterReset:       m.onAfterGroupChannelReset,
-			})
-			return &channelRecord{
-				when:    time.Now(),
-				channel: newChannel,
+			if meeting.MaxParticipants > 0 {
+				maxParticipants = meeting.MaxParticipants
 			}
-		})
-		if record == nil {
-			// We are fucked.
-			err = fmt.Errorf("channel upsert without result")
-			m.logger.WithError(err).WithField("channel", channelID).Errorln("failed to create channel for group")
+		}
+		// Get or create channel with ID.
+		channel, err := m.upsertGroupChannel(channelID, msg.Group, maxParticipants)
+		if err != nil {
 			return err
 		}
 
-		channel := record.(*channelRecord).channel
-
 		// Ensure that the channel is not empty.
 		if auth != nil && !auth.CanCreateChannels && channel.Size() == 0 {
 			return api.NewRTMTypeError(api.RTMErrorIDCreateRestricted, "access denied", msg.ID)
 		}
-
-		// Add user connection.
-		err = channel.Add(ur.id, c)
-		if err != nil {
-			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
+		// Ensure that the user was not kicked recently.
+		if channel.isBlocked(ur.id) {
+			return api.NewRTMTypeError(api.RTMErrorIDAccessRestricted, "removed from channel by moderator", msg.ID)
 		}
 
-		// Create hash for channel.
-		//m.logger.Debugln("webrtc_group hash", channel.id, ur.id, msg.Group)
-		hash := computeWebRTCChannelHash(msg.Type, ur.id, msg.Group, channel.id)
-
-		// Add source, channel and hash.
-		msg.Source = ur.id
-		msg.Channel = channel.id
-		msg.Hash = base64.StdEncoding.EncodeToString(hash)
-
-		// Get IDs of members in channel.
-		members, _ := channel.Connections()
+		if cc, _ := channel.Get(ur.id); cc == nil {
+			// Ensure that the passcode is known, unless moderator.
+			if !m.isChannelModerator(channel, ur) {
+				if err = m.checkGroupPasscode(msg, ur); err != nil {
+					return err
+				}
+			}
+			// Ensure that the channel has room, unless already in it.
+			if channel.IsFull() {
+				return api.NewRTMTypeError(api.RTMErrorIDChannelFull, "channel is full", msg.ID)
+			}
+		}
 
-		data := &api.RTMDataWebRTCChannelExtra{}
-		data.Group = &api.RTMTDataWebRTCChannelGroup{
-			Group:   msg.Group,
-			Members: members,
-			Reset:   true,
+		// Hold back in lobby, until admitted.
+		if m.needsGroupLobby(channel, ur, auth) {
+			return m.enterGroupLobby(c, msg, ur, channel)
 		}
-		if pipeline := channel.Pipeline(); pipeline != nil {
-			data.Pipeline = &api.RTMDataWebRTCChannelPipeline{
-				Pipeline: pipeline.ID(),
-				Mode:     pipeline.Mode(),
-			}
+
+		if err = m.joinGroupChannel(c, msg, ur, auth, channel); err != nil {
+			return err
 		}
-		extra, err := json.MarshalIndent(data, "", "\t")
-		if err != nil {
-			return fmt.Errorf("failed to encode group data: %v", err)
-		}
-
-		// Send to self to populate channel and hash.
-		c.Send(&api.RTMTypeWebRTCReply{
-			RTMTypeSubtypeEnvelopeReply: &api.RTMTypeSubtypeEnvelopeReply{
-				Type:    api.RTMTypeNameWebRTC,
-				Subtype: api.RTMSubtypeNameWebRTCChannel,
-				ReplyTo: msg.ID,
-			},
-			Channel: msg.Channel,
-			Hash:    msg.Hash,
-			Data:    extra,
-			Version: currentWebRTCPayloadVersion,
-		})
+		// Let the new member know who is waiting.
+		m.sendGroupLobby(channel, false)
 
 	case api.RTMSubtypeNameWebRTCCall:
 		// Connection must have a user.
 		if ur == nil {
 			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "connection has no user", msg.ID)
 		}
-		// Target must always be not empty.
-		if msg.Target == "" {
-			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "target is empty", msg.ID)
-		}
-		// Target cannot be the same as source.
-		if msg.Target == ur.id {
-			return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "target same as source", msg.ID)
+		// Requests can ring multiple targets, given as list or hunt group.
+		multi := msg.Initiator && (len(msg.Targets) > 0 || msg.Hunt != "")
+		if multi {
+			// Target must be empty with multiple targets.
+			if msg.Target != "" {
+				return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "target must be empty with targets", msg.ID)
+			}
+		} else {
+			// Target must always be not empty.
+			if msg.Target == "" {
+				return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "target is empty", msg.ID)
+			}
+			// Target cannot be the same as source.
+			if msg.Target == ur.id {
+				return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "target same as source", msg.ID)
+			}
 		}
 		// State must always be not empty.
 		if msg.State == "" {
@@ -274,6 +262,13 @@ func (m *Manager) processWebRTCMessage(c *connection.Connection, msg *api.RTMTyp
 			if auth != nil && !auth.CanCreateChannels {
 				return api.NewRTMTypeError(api.RTMErrorIDCreateRestricted, "access denied", msg.ID)
 			}
+			targets := []string{msg.Target}
+			if multi {
+				targets, err = m.getCallTargets(ur.id, msg)
+				if err != nil {
+					return err
+				}
+			}
 
 			// Create channel and add user with connection.
 			channel := CreateRandomChannel(m, nil)
@@ -300,8 +295,13 @@ func (m *Manager) processWebRTCMessage(c *connection.Connection, msg *api.RTMTyp
 				}
 			}
 
-			// Create hash for channel.
-			hash := computeWebRTCChannelHash(msg.Type, ur.id, msg.Target, channel.id)
+			// Create hash for channel. Calls with multiple targets use the
+			// channel as target, until answered.
+			peer := msg.Target
+			if multi {
+				peer = channel.id
+			}
+			hash := computeWebRTCChannelHash(msg.Type, ur.id, peer, channel.id)
 
 			// Add source, channel and hash.
 			msg.Source = ur.id
@@ -328,15 +328,35 @@ func (m *Manager) processWebRTCMessage(c *connection.Connection, msg *api.RTMTyp
 			// TODO(longsleep): Add transaction and gather all targets in a
 			// single reply.
 
-			// Lookup target and send modified message.
-			connections, ok := m.LookupConnectionsByID(msg.Target)
-			if !ok {
-				return api.NewRTMTypeError(api.RTMErrorIDNoSessionForUser, "target not found", msg.ID)
+			// Lookup targets and send modified message, with the hash of
+			// each target.
+			ringing := make([]string, 0, len(targets))
+			for _, target := range targets {
+				connections, ok := m.LookupConnectionsByID(target)
+				if !ok {
+					continue
+				}
+				targetMsg := msg
+				if multi {
+					targetMsg = &api.RTMTypeWebRTC{}
+					*targetMsg = *msg
+					targetMsg.Target = target
+					targetMsg.Targets = nil
+					targetMsg.Hash = base64.StdEncoding.EncodeToString(computeWebRTCChannelHash(msg.Type, ur.id, target, channel.id))
+				}
+				for _, connection := range connections {
+					connection.Send(targetMsg)
+				}
+				ringing = append(ringing, target)
 			}
-			for _, connection := range connections {
-				connection.Send(msg)
+			if len(ringing) == 0 {
+				return api.NewRTMTypeError(api.RTMErrorIDNoSessionForUser, "target not found", msg.ID)
 			}
 
+			// Track invitation, to hang up automatically when not answered in
+			// time.
+			m.startCallRing(channel, ur.id, ringing, multi)
+
 		} else {
 			// Must be a response.
 			if msg.Channel == "" || msg.Hash == "" || msg.Data == nil {
@@ -377,14 +397,6 @@ func (m *Manager) processWebRTCMessage(c *connection.Connection, msg *api.RTMTyp
 
 			} else {
 				// Normal call accept.
-				if extra.Accept {
-					// Add to channel when accept.
-					err = channel.Add(ur.id, c)
-					if err != nil {
-						return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
-					}
-				}
-
 				if ur != nil {
 					// Additional actions based on the target.
 					connections, exists := m.LookupConnectionsByID(ur.id)
@@ -416,6 +428,34 @@ func (m *Manager) processWebRTCMessage(c *connection.Connection, msg *api.RTMTyp
 						}
 					}
 				}
+
+				// The first accept wins when ringing multiple targets and
+				// rejects are only forwarded once all targets have rejected.
+				ring, forward := channel.answerCallRing(ur.id, extra.Accept)
+				if !forward {
+					if extra.Accept {
+						return api.NewRTMTypeError(api.RTMErrorIDAnsweredElsewhere, "call answered elsewhere", msg.ID)
+					}
+					return nil
+				}
+
+				if extra.Accept {
+					// Add to channel when accept.
+					err = channel.Add(ur.id, c)
+					if err != nil {
+						return api.NewRTMTypeError(api.RTMErrorIDBadMessage, err.Error(), msg.ID)
+					}
+					if ring != nil && ring.multi {
+						// Cancel at everyone else and let the caller know
+						// the hash for the target who answered.
+						m.clearCallRing(channel, ring)
+						m.sendCallRingAnswered(channel, ring)
+					}
+				} else if ring != nil && ring.multi {
+					// Send a single reject for all targets to the caller,
+					// with the channel as peer like the call.
+					return m.sendCallRingRejected(channel, ring, extra)
+				}
 			}
 
 			// Add source and profile, then send modified message.
@@ -481,6 +521,15 @@ func (m *Manager) processWebRTCMessage(c *connection.Connection, msg *api.RTMTyp
 		}
 
 		if msg.Subtype == api.RTMSubtypeNameWebRTCHangup {
+			if msg.Target == msg.Channel {
+				// Hangup of a call with multiple targets by the caller, before
+				// it was answered.
+				if ring := channel.cancelCallRing(ur.id); ring != nil {
+					m.hangupCallRing(channel, ring, "", false)
+				}
+				break
+			}
+			channel.stopCallRing()
 			// XXX(longsleep): Find a better way to remove ourselves from channels.
 			channel.Remove(ur.id)
 			if !ok {
@@ -514,6 +563,24 @@ func (m *Manager) processWebRTCMessage(c *connection.Connection, msg *api.RTMTyp
 			return api.NewRTMTypeError(api.RTMErrorIDNoSessionForUser, "target not found", msg.ID)
 		}
 
+	case api.RTMSubtypeNameWebRTCKick, api.RTMSubtypeNameWebRTCMute, api.RTMSubtypeNameWebRTCModerator:
+		return m.processWebRTCModeration(c, msg, ur)
+
+	case api.RTMSubtypeNameWebRTCAdmit, api.RTMSubtypeNameWebRTCDeny:
+		return m.processWebRTCLobby(c, msg, ur)
+
+	case api.RTMSubtypeNameWebRTCState:
+		return m.processWebRTCState(c, msg, ur)
+
+	case api.RTMSubtypeNameWebRTCPasscode:
+		return m.processWebRTCPasscode(c, msg, ur)
+
+	case api.RTMSubtypeNameWebRTCTransfer:
+		return m.processWebRTCTransfer(c, msg, ur, auth)
+
+	case api.RTMSubtypeNameWebRTCEscalate:
+		return m.processWebRTCEscalate(c, msg, ur, auth)
+
 	default:
 		return api.NewRTMTypeError(api.RTMErrorIDBadMessage, "unknown subtype", msg.ID)
 	}
@@ -40,8 +40,10 @@ import (
 	"stash.kopano.io/kwm/kwmserver/signaling/admin"
 	apiv1 "stash.kopano.io/kwm/kwmserver/signaling/api-v1/service"
 	apiv2 "stash.kopano.io/kwm/kwmserver/signaling/api-v2/service"
+	"stash.kopano.io/kwm/kwmserver/signaling/chats"
 	"stash.kopano.io/kwm/kwmserver/signaling/guest"
 	"stash.kopano.io/kwm/kwmserver/signaling/mcu"
+	"stash.kopano.io/kwm/kwmserver/signaling/meetings"
 	"stash.kopano.io/kwm/kwmserver/signaling/rtm"
 	"stash.kopano.io/kwm/kwmserver/signaling/www"
 	"stash.kopano.io/kwm/kwmserver/turn"
@@ -264,6 +266,75 @@ func (s *Server) Serve(ctx context.Context) error {
 	var rtmm *rtm.Manager
 	if s.config.EnableRTMAPI {
 		rtmm = rtm.NewManager(serveCtx, "", s.config.AllowInsecureAuth, s.config.RTMRequiredScopes, s.config.PipelineForcedPattern, logger, mcum, adminm, guestm, oidcp, turnsrv)
+		if s.config.RTMSessionResumeGrace > 0 {
+			rtmm.SetSessionResumeGrace(time.Duration(s.config.RTMSessionResumeGrace) * time.Second)
+		}
+		if s.config.RTMSessionReplaySize > 0 {
+			rtmm.SetSessionReplaySize(s.config.RTMSessionReplaySize)
+		}
+		if s.config.RTMMaxConnectionsPerUser > 0 {
+			if err := rtmm.SetConnectionLimit(s.config.RTMMaxConnectionsPerUser, s.config.RTMConnectionLimitPolicy); err != nil {
+				return fmt.Errorf("unable to set rtm connection limit: %v", err)
+			}
+		}
+		if err := rtmm.SetRateLimits(s.config.RTMRateLimits, s.config.RTMRateLimitDisconnect); err != nil {
+			return fmt.Errorf("invalid rtm rate limits: %v", err)
+		}
+		if s.config.RTMGroupLobbyPattern != "" {
+			if err := rtmm.SetGroupLobby(s.config.RTMGroupLobbyPattern, s.config.RTMGroupLobbyGuestsOnly); err != nil {
+				return fmt.Errorf("invalid rtm group lobby regexp: %v", err)
+			}
+		}
+		if err := rtmm.SetGroupMaxParticipants(s.config.RTMGroupMaxParticipants, s.config.RTMGroupMaxParticipantsRules); err != nil {
+			return fmt.Errorf("invalid rtm group max participants: %v", err)
+		}
+		rtmm.SetProfileClaims(s.config.RTMProfileClaims)
+		if err := rtmm.SetCallRingTimeout(time.Duration(s.config.RTMCallRingTimeout) * time.Second); err != nil {
+			return fmt.Errorf("invalid rtm call ring timeout: %v", err)
+		}
+		if err := rtmm.SetHuntGroups(s.config.RTMHuntGroups); err != nil {
+			return fmt.Errorf("invalid rtm hunt groups: %v", err)
+		}
+		if err := rtmm.SetDrain(time.Duration(s.config.RTMDrainTimeout)*time.Second, s.config.RTMDrainURL, time.Duration(s.config.RTMDrainRetryAfter)*time.Second); err != nil {
+			return fmt.Errorf("invalid rtm drain settings: %v", err)
+		}
+		if s.config.RTMMeetingsFilePath != "" {
+			meetingsStore, storeErr := meetings.NewFileStore(serveCtx, s.config.RTMMeetingsFilePath, logger)
+			if storeErr != nil {
+				return fmt.Errorf("failed to open meetings file: %v", storeErr)
+			}
+			defer meetingsStore.Close()
+			rtmm.SetMeetingsStore(meetingsStore)
+			logger.WithField("file", s.config.RTMMeetingsFilePath).Infoln("rtm: scheduled meetings enabled")
+		}
+		if s.config.ChatsHistoryDatabasePath != "" {
+			retention, retentionErr := chats.NewRetention(s.config.ChatsHistoryRetention)
+			if retentionErr != nil {
+				return fmt.Errorf("invalid chats history retention: %v", retentionErr)
+			}
+			chatsStore, storeErr := chats.NewBoltStore(serveCtx, s.config.ChatsHistoryDatabasePath, retention, logger)
+			if storeErr != nil {
+				return fmt.Errorf("failed to open chats history database: %v", storeErr)
+			}
+			defer chatsStore.Close()
+			rtmm.SetChatsStore(chatsStore)
+			logger.WithField("db", s.config.ChatsHistoryDatabasePath).Infoln("rtm: chats history enabled")
+		}
+		if s.config.ChatsRichTextPolicy != "" {
+			allow := s.config.ChatsRichTextAllow
+			if len(allow) == 0 {
+				allow = chats.DefaultRichTextAllow
+			}
+			schemes := s.config.ChatsRichTextSchemes
+			if len(schemes) == 0 {
+				schemes = chats.DefaultRichTextSchemes
+			}
+			policy, policyErr := chats.NewRichTextPolicy(s.config.ChatsRichTextPolicy, allow, schemes)
+			if policyErr != nil {
+				return fmt.Errorf("invalid chats rich text policy: %v", policyErr)
+			}
+			rtmm.SetChatsRichTextPolicy(policy)
+		}
 		services.RTMManager = rtmm
 		collector := rtm.NewManagerCollector(rtmm)
 		if s.config.Metrics != nil {
@@ -340,14 +411,51 @@ func (s *Server) Serve(ctx context.Context) error {
 	}()
 	logger.Infoln("ready to handle requests")
 
+	// Drain is triggered either by SIGTERM or through the admin API.
+	var drainCh <-chan struct{}
+	var drainTimeout time.Duration
+	if rtmm != nil {
+		drainCh = rtmm.Draining()
+		drainTimeout = rtmm.DrainTimeout()
+	}
+
 	// Wait for exit or error.
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	drain := false
 	select {
 	case err = <-errCh:
 		// breaks
 	case reason := <-signalCh:
 		logger.WithField("signal", reason).Warnln("received signal")
+		drain = reason == syscall.SIGTERM && drainTimeout > 0
 		// breaks
+	case <-drainCh:
+		drain = true
+		// breaks
+	}
+
+	if drain {
+		rtmm.Drain()
+		logger.WithField("timeout", drainTimeout).Infoln("draining, waiting for active group channels")
+		func() {
+			deadline := time.After(drainTimeout)
+			for {
+				numActive := rtmm.NumActiveGroupChannels()
+				if numActive == 0 {
+					return
+				}
+				select {
+				case reason := <-signalCh:
+					logger.WithField("signal", reason).Warn("received signal, drain aborted")
+					return
+				case <-deadline:
+					logger.WithField("channels", numActive).Warn("drain timeout reached")
+					return
+				case <-time.After(1 * time.Second):
+				}
+			}
+		}()
+		logger.Infoln("drain complete")
 	}
 
 	// Shutdown, server will stop to accept new connections, requires Go 1.8+.